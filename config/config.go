@@ -0,0 +1,289 @@
+// Package config loads the settings of a server from, in order of
+// precedence, its command line, environment variables, an optional
+// configuration file and the defaults of its flags.
+//
+// Every setting is a flag, so flag.PrintDefaults documents them all. The
+// environment variable for the flag mongo-uri of a server with the prefix
+// INVENTORY is INVENTORY_MONGO_URI, unless Options.Env names another. The
+// file, named by the -config flag or the PREFIX_CONFIG variable, holds
+// top-level TOML key = value pairs, one per flag:
+//
+//	# Where the webserver listens.
+//	addr = ":8000"
+//	legacy = true
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Options says where a server's settings come from.
+type Options struct {
+	// EnvPrefix starts the names of the environment variables for flags.
+	EnvPrefix string
+	// Env names the environment variables of flags that do not follow the
+	// prefix, such as variables set by Docker Compose or shared with other
+	// programs.
+	Env map[string]string
+	// Secrets are flags whose values are never shown. Flags whose names
+	// contain key, secret, token or password are always secret.
+	Secrets []string
+	// Validate, if set, checks the settings once they are loaded.
+	Validate func() error
+}
+
+// A Config is the loaded settings of a server, noting where each came from.
+type Config struct {
+	fs      *flag.FlagSet
+	opts    Options
+	sources map[string]string // By flag name; flags left at their default are missing.
+}
+
+// Load parses args with fs, after adding to it a -config flag, and then
+// sets each flag not given in args from its environment variable or, if
+// that is unset, the configuration file.
+func Load(fs *flag.FlagSet, args []string, opts Options) (*Config, error) {
+	file := fs.String("config", "", "TOML file of settings, overridden by environment variables and flags (or $"+opts.envName("config")+")")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	c := &Config{fs: fs, opts: opts, sources: map[string]string{}}
+	fs.Visit(func(f *flag.Flag) { c.sources[f.Name] = "flag" })
+
+	if *file == "" {
+		if v, ok := os.LookupEnv(opts.envName("config")); ok {
+			*file = v
+			c.sources["config"] = "env " + opts.envName("config")
+		}
+	}
+	var settings map[string]string
+	if *file != "" {
+		var err error
+		if settings, err = readFile(*file); err != nil {
+			return nil, err
+		}
+		for name := range settings {
+			if name == "config" || fs.Lookup(name) == nil {
+				return nil, fmt.Errorf("%s: unknown setting %q", *file, name)
+			}
+		}
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, set := c.sources[f.Name]; set || f.Name == "config" {
+			return
+		}
+		env := opts.envName(f.Name)
+		value, ok := os.LookupEnv(env)
+		source := "env " + env
+		if !ok {
+			value, ok = settings[f.Name]
+			source = "file " + *file
+		}
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s from %s: %v", f.Name, source, err))
+			return
+		}
+		c.sources[f.Name] = source
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if opts.Validate != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// envName returns the environment variable for the named flag.
+func (o Options) envName(name string) string {
+	if env, ok := o.Env[name]; ok {
+		return env
+	}
+	return strings.ToUpper(o.EnvPrefix + "_" + strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// secret reports whether the named flag holds a secret.
+func (o Options) secret(name string) bool {
+	for _, s := range o.Secrets {
+		if s == name {
+			return true
+		}
+	}
+	for _, word := range []string{"key", "secret", "token", "password"} {
+		if strings.Contains(strings.ToLower(name), word) {
+			return true
+		}
+	}
+	return false
+}
+
+// A Setting is the effective value of a flag, fit to be shown.
+type Setting struct {
+	Name   string
+	Value  string // Redacted if it is a secret, or a URL with a password.
+	Source string // "default", "flag", "env NAME" or "file PATH".
+}
+
+// Settings returns the effective value of every flag, by name.
+func (c *Config) Settings() []Setting {
+	var settings []Setting
+	c.fs.VisitAll(func(f *flag.Flag) {
+		s := Setting{Name: f.Name, Value: f.Value.String(), Source: c.sources[f.Name]}
+		if s.Source == "" {
+			s.Source = "default"
+		}
+		switch {
+		case s.Value == "":
+		case c.opts.secret(f.Name):
+			s.Value = "REDACTED"
+		default:
+			if u, err := url.Parse(s.Value); err == nil && u.User != nil {
+				s.Value = u.Redacted()
+			}
+		}
+		settings = append(settings, s)
+	})
+	return settings
+}
+
+// String lists the effective settings, one per line, for logging at
+// startup.
+func (c *Config) String() string {
+	var b strings.Builder
+	for _, s := range c.Settings() {
+		fmt.Fprintf(&b, "%s = %q (%s)\n", s.Name, s.Value, s.Source)
+	}
+	return b.String()
+}
+
+// LogValue groups the effective settings for structured logs.
+func (c *Config) LogValue() slog.Value {
+	var attrs []slog.Attr
+	for _, s := range c.Settings() {
+		attrs = append(attrs, slog.String(s.Name, s.Value))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// readFile reads the settings in a TOML file, which must be top-level
+// key = value pairs whose values are strings, numbers or booleans.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	settings := map[string]string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: want key = value", path, i+1)
+		}
+		if key, err = unquote(key); err != nil {
+			return nil, fmt.Errorf("%s:%d: key: %v", path, i+1, err)
+		}
+		if _, dup := settings[key]; dup {
+			return nil, fmt.Errorf("%s:%d: %s is set twice", path, i+1, key)
+		}
+		if settings[key], err = parseValue(value); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %v", path, i+1, key, err)
+		}
+	}
+	return settings, nil
+}
+
+// parseValue returns the text of a TOML string, number or boolean, less
+// any comment after it.
+func parseValue(v string) (string, error) {
+	switch {
+	case v == "":
+		return "", errors.New("missing value")
+	case v[0] == '"' || v[0] == '\'':
+		end := -1
+		for i := 1; i < len(v) && end < 0; i++ {
+			switch {
+			case v[i] == '\\' && v[0] == '"':
+				i++ // Skip what is escaped.
+			case v[i] == v[0]:
+				end = i
+			}
+		}
+		if end < 0 {
+			return "", errors.New("unterminated string")
+		}
+		if rest := strings.TrimSpace(v[end+1:]); rest != "" && rest[0] != '#' {
+			return "", fmt.Errorf("unexpected %q after string", rest)
+		}
+		return unquote(v[:end+1])
+	}
+	if i := strings.IndexByte(v, '#'); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	if v == "true" || v == "false" {
+		return v, nil
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(v, "_", ""), 64); err != nil {
+		return "", fmt.Errorf("%q is not a string, number or boolean", v)
+	}
+	return strings.ReplaceAll(v, "_", ""), nil
+}
+
+// unquote returns s without its quotes, if it has any. Basic strings
+// ("...") have Go's escapes, which cover TOML's; literal strings ('...')
+// have none.
+func unquote(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return s[1 : len(s)-1], nil
+	case len(s) >= 2 && s[0] == '"':
+		return strconv.Unquote(s)
+	}
+	return s, nil
+}
+
+// CheckAddr reports an error unless the named setting is a host:port
+// address to listen on or dial.
+func CheckAddr(name, addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %v", name, addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid %s %q: bad port %q", name, addr, port)
+	}
+	return nil
+}
+
+// CheckURL reports an error unless the named setting is an absolute URL
+// with one of the given schemes.
+func CheckURL(name, value string, schemes ...string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", name, err)
+	}
+	for _, s := range schemes {
+		if u.Scheme == s && u.Host != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid %s %q: want a %s URL", name, u.Redacted(), strings.Join(schemes, " or "))
+}
@@ -0,0 +1,3 @@
+module github.com/VahidBabaey/CloudComputing/config
+
+go 1.21.6
@@ -0,0 +1,252 @@
+package inventory
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Roles a principal can have. An admin can do everything a reader can.
+const (
+	RoleReader = "reader" // Can read items.
+	RoleAdmin  = "admin"  // Can also change items, reserve and order.
+)
+
+const (
+	minTokenKeySize = 32               // Bytes; HS256 keys shorter than its hash are weak.
+	tokenLeeway     = 30 * time.Second // Clock skew allowed when checking a token's times.
+)
+
+// errUnauthenticated is returned for a request with missing or bad
+// credentials.
+var errUnauthenticated = errors.New("missing or invalid credentials")
+
+// A Principal is who a request was made by.
+type Principal struct {
+	Name string
+	Role string
+}
+
+// can reports whether p has role, or a role that includes it.
+func (p Principal) can(role string) bool { return p.Role == RoleAdmin || p.Role == role }
+
+// An Authenticator checks the credentials of requests, which are either
+// API keys or JSON Web Tokens signed with HMAC-SHA256 (HS256). Tokens are
+// checked with the local key alone, so any service holding the key can
+// issue them.
+type Authenticator struct {
+	keys     map[[sha256.Size]byte]Principal // By the hash of the key, so lookups do not leak it through timing.
+	tokenKey []byte
+	now      func() time.Time
+}
+
+// NewAuthenticator returns an authenticator accepting the API keys in
+// keys, a comma-separated list of name:role:key entries, and tokens signed
+// with tokenKey. It returns nil if both are empty, which leaves the API
+// open to everyone.
+func NewAuthenticator(keys, tokenKey string) (*Authenticator, error) {
+	if keys == "" && tokenKey == "" {
+		return nil, nil
+	}
+	a := &Authenticator{keys: map[[sha256.Size]byte]Principal{}, now: time.Now}
+	if tokenKey != "" {
+		if len(tokenKey) < minTokenKeySize {
+			return nil, fmt.Errorf("token key is %d bytes; want at least %d", len(tokenKey), minTokenKeySize)
+		}
+		a.tokenKey = []byte(tokenKey)
+	}
+	for _, entry := range strings.Split(keys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, rest, _ := strings.Cut(entry, ":")
+		role, key, _ := strings.Cut(rest, ":")
+		if name == "" || key == "" || !validRole(role) {
+			return nil, fmt.Errorf("invalid API key entry for %q; want name:%s|%s:key", name, RoleReader, RoleAdmin)
+		}
+		a.keys[sha256.Sum256([]byte(key))] = Principal{name, role}
+	}
+	return a, nil
+}
+
+func validRole(role string) bool { return role == RoleReader || role == RoleAdmin }
+
+// authenticate returns the principal whose credentials req carries, in an
+// "Authorization: Bearer" or "X-API-Key" header.
+func (a *Authenticator) authenticate(req *http.Request) (Principal, error) {
+	credential := req.Header.Get("X-API-Key")
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		scheme, token, _ := strings.Cut(auth, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return Principal{}, errUnauthenticated
+		}
+		credential = strings.TrimSpace(token)
+	}
+	if credential == "" {
+		return Principal{}, errUnauthenticated
+	}
+	if p, ok := a.keys[sha256.Sum256([]byte(credential))]; ok {
+		return p, nil
+	}
+	if a.tokenKey != nil && strings.Count(credential, ".") == 2 {
+		return a.verifyToken(credential)
+	}
+	return Principal{}, errUnauthenticated
+}
+
+// tokenHeader is the JOSE header of issued tokens. Only HS256 is accepted,
+// so that "none" or an asymmetric algorithm cannot be substituted.
+const tokenHeader = `{"alg":"HS256","typ":"JWT"}`
+
+// claims are the parts of a token's payload that are used.
+type claims struct {
+	Subject   string `json:"sub"`
+	Role      string `json:"role"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	Expires   int64  `json:"exp"`
+}
+
+// verifyToken checks a token's signature and times, returning its
+// principal.
+func (a *Authenticator) verifyToken(token string) (Principal, error) {
+	header, rest, _ := strings.Cut(token, ".")
+	payload, signature, _ := strings.Cut(rest, ".")
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, sign(a.tokenKey, header+"."+payload)) {
+		return Principal{}, errUnauthenticated
+	}
+	h, err := base64.RawURLEncoding.DecodeString(header)
+	if err != nil {
+		return Principal{}, errUnauthenticated
+	}
+	var hdr struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(h, &hdr) != nil || hdr.Alg != "HS256" {
+		return Principal{}, errUnauthenticated
+	}
+	p, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Principal{}, errUnauthenticated
+	}
+	var c claims
+	if json.Unmarshal(p, &c) != nil || c.Subject == "" || !validRole(c.Role) || c.Expires == 0 {
+		return Principal{}, errUnauthenticated
+	}
+	now := a.now()
+	if now.After(time.Unix(c.Expires, 0).Add(tokenLeeway)) || c.NotBefore != 0 && now.Add(tokenLeeway).Before(time.Unix(c.NotBefore, 0)) {
+		return Principal{}, errUnauthenticated
+	}
+	return Principal{c.Subject, c.Role}, nil
+}
+
+// SignToken returns a token for p, valid for ttl from now, signed with key.
+func SignToken(key []byte, p Principal, ttl time.Duration) (string, error) {
+	if len(key) < minTokenKeySize {
+		return "", fmt.Errorf("token key is %d bytes; want at least %d", len(key), minTokenKeySize)
+	}
+	if p.Name == "" || !validRole(p.Role) {
+		return "", fmt.Errorf("invalid principal %q with role %q", p.Name, p.Role)
+	}
+	now := time.Now()
+	payload, err := json.Marshal(claims{Subject: p.Name, Role: p.Role, IssuedAt: now.Unix(), Expires: now.Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString([]byte(tokenHeader)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(key, signed)), nil
+}
+
+func sign(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// requiredRole returns the role needed to make req: reading needs a
+// reader, and anything that changes the inventory an admin. The legacy
+// routes change data on GET, so they go by path, and the health and
+// metrics endpoints, for probes and scrapers, need none.
+func requiredRole(req *http.Request) string {
+	switch req.URL.Path {
+	case "/healthz", "/readyz", "/metrics":
+		return ""
+	case "/list", "/price":
+		return RoleReader
+	case "/create", "/update", "/delete":
+		return RoleAdmin
+	}
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return RoleReader
+	}
+	return RoleAdmin
+}
+
+// An auditRecord is one line of the audit log: who asked to change what,
+// and how it went.
+type auditRecord struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"` // Empty when authentication is off.
+	Role   string    `json:"role,omitempty"`
+	Method string    `json:"method"`
+	URI    string    `json:"uri"`
+	Status int       `json:"status"`
+	// RequestID matches the record with the request's access log line.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// An auditLog writes audit records as JSON lines.
+type auditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (l *auditLog) record(r auditRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enc.Encode(r)
+}
+
+// authorize wraps next so that requests must carry the credentials of a
+// principal with the role they need, if s.Auth is set, and so that every
+// attempt to change the inventory is recorded in s.Audit, if set.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	var audit *auditLog
+	if s.Audit != nil {
+		audit = &auditLog{enc: json.NewEncoder(s.Audit)}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		role := requiredRole(req)
+		var p Principal
+		if audit != nil && role == RoleAdmin {
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			w = rec
+			defer func() {
+				audit.record(auditRecord{time.Now().UTC(), p.Name, p.Role, req.Method, req.URL.RequestURI(), rec.status, RequestID(req.Context())})
+			}()
+		}
+		if s.Auth != nil && role != "" {
+			var err error
+			if p, err = s.Auth.authenticate(req); err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="inventory"`)
+				writeError(w, http.StatusUnauthorized, "%v", err)
+				return
+			}
+			if !p.can(role) {
+				writeError(w, http.StatusForbidden, "%s may not %s %s; that needs the %s role", p.Name, req.Method, req.URL.Path, role)
+				return
+			}
+			req = req.WithContext(ContextWithPrincipal(req.Context(), p))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
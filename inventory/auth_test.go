@@ -0,0 +1,141 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTokenKey = "0123456789abcdef0123456789abcdef"
+
+func TestTokens(t *testing.T) {
+	t.Parallel()
+	a, err := NewAuthenticator("", testTokenKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := Principal{"alice", RoleAdmin}
+	token, err := SignToken([]byte(testTokenKey), alice, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := a.verifyToken(token); err != nil || p != alice {
+		t.Errorf("verifyToken = %v, %v; want %v", p, err, alice)
+	}
+
+	header, rest, _ := strings.Cut(token, ".")
+	payload, _, _ := strings.Cut(rest, ".")
+	enc := base64.RawURLEncoding.EncodeToString
+	other, _ := SignToken([]byte(strings.Repeat("x", 32)), alice, time.Hour)
+	expired, _ := SignToken([]byte(testTokenKey), alice, -time.Hour)
+	bob, _ := SignToken([]byte(testTokenKey), Principal{"bob", RoleReader}, time.Hour)
+	_, bobRest, _ := strings.Cut(bob, ".")
+	bobPayload, _, _ := strings.Cut(bobRest, ".")
+	for name, bad := range map[string]string{
+		"other key":        other,
+		"expired":          expired,
+		"swapped payload":  header + "." + bobPayload + "." + token[strings.LastIndex(token, ".")+1:],
+		"alg none":         enc([]byte(`{"alg":"none"}`)) + "." + payload + ".",
+		"no signature":     header + "." + payload + ".",
+		"garbage":          "a.b.c",
+		"missing sections": header + "." + payload,
+	} {
+		if p, err := a.verifyToken(bad); err == nil {
+			t.Errorf("%s: verified as %v", name, p)
+		}
+	}
+
+	a.now = func() time.Time { return time.Now().Add(-time.Hour) }
+	if _, err := a.verifyToken(expired); err != nil {
+		t.Errorf("token rejected before it expired: %v", err)
+	}
+
+	if _, err := SignToken([]byte("short"), alice, time.Hour); err == nil {
+		t.Error("signed with a short key")
+	}
+	for _, keys := range []string{"alice:root:k", "alice:admin:", ":admin:k"} {
+		if _, err := NewAuthenticator(keys, ""); err == nil {
+			t.Errorf("NewAuthenticator(%q) succeeded", keys)
+		}
+	}
+	if a, err := NewAuthenticator("", ""); a != nil || err != nil {
+		t.Errorf("NewAuthenticator with no credentials = %v, %v; want nil", a, err)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	t.Parallel()
+	auth, err := NewAuthenticator("alice:admin:sesame, bob:reader:letmein", testTokenKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var audit bytes.Buffer
+	s := &Server{Store: NewMemoryStore(DefaultItems...), Legacy: true, Auth: auth, Audit: &audit}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	carol, _ := SignToken([]byte(testTokenKey), Principal{"carol", RoleAdmin}, time.Hour)
+
+	tests := []struct {
+		method, path, header, body string
+		status                     int
+	}{
+		{"GET", "/items", "", "", 401},
+		{"GET", "/items", "Authorization: Bearer nope", "", 401},
+		{"GET", "/items", "Authorization: Basic bGV0bWVpbg==", "", 401},
+		{"GET", "/items", "Authorization: Bearer letmein", "", 200},
+		{"GET", "/price?item=shoes", "X-API-Key: letmein", "", 200},
+		{"GET", "/delete?item=shoes", "X-API-Key: letmein", "", 403},
+		{"PATCH", "/items/shoes", "X-API-Key: letmein", `{"quantity":1}`, 403},
+		{"POST", "/orders", "X-API-Key: letmein", `{"lines":[{"item":"socks","quantity":1}]}`, 403},
+		{"PATCH", "/items/shoes", "Authorization: Bearer sesame", `{"quantity":1}`, 200},
+		{"POST", "/items", "Authorization: Bearer " + carol, `{"name":"hats","price":1}`, 201},
+		{"GET", "/delete?item=hats", "Authorization: bearer " + carol, "", 200},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+		if name, value, ok := strings.Cut(tt.header, ": "); ok {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s with %q: status %d, want %d", tt.method, tt.path, tt.header, resp.StatusCode, tt.status)
+		}
+		if resp.StatusCode == 401 && resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %s: 401 without WWW-Authenticate", tt.method, tt.path)
+		}
+	}
+
+	// Only attempted changes are audited, refused or not.
+	var got []string
+	dec := json.NewDecoder(&audit)
+	for dec.More() {
+		var r auditRecord
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Time.IsZero() {
+			t.Errorf("audit record %+v has no time", r)
+		}
+		got = append(got, strings.Join([]string{r.User, r.Role, r.Method, r.URI, http.StatusText(r.Status)}, " "))
+	}
+	want := []string{
+		"bob reader GET /delete?item=shoes Forbidden",
+		"bob reader PATCH /items/shoes Forbidden",
+		"bob reader POST /orders Forbidden",
+		"alice admin PATCH /items/shoes OK",
+		"carol admin POST /items Created",
+		"carol admin GET /delete?item=hats OK",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("audit log:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,394 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxImportSize = 32 << 20 // Largest body accepted by POST /items:import.
+
+// csvHeader is the first record of an exported CSV file. Imports need the
+// name and price columns, in any order; the others are optional. Tags are
+// separated by spaces.
+var csvHeader = []string{"name", "price", "currency", "quantity", "category", "tags", "description"}
+
+// An importResult is the response to POST /items:import.
+type importResult struct {
+	DryRun  bool       `json:"dry_run"`
+	Created int        `json:"created"`
+	Updated int        `json:"updated"`
+	Errors  []rowError `json:"errors"`
+}
+
+// A rowError reports a row that was not imported. Rows count from 1,
+// leaving out a CSV file's header.
+type rowError struct {
+	Row     int    `json:"row"`
+	Name    string `json:"name,omitempty"`
+	Message string `json:"message"`
+}
+
+// importItems handles "/items:import": POST creates the items in a CSV file
+// or JSON array, or with ?mode=upsert also updates those that exist.
+// Every row is checked before any is written, and if any is bad nothing is
+// written; ?dry_run=true only checks. Rows that fail despite the checks,
+// because of another client's changes, are reported likewise.
+func (s *Server) importItems(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	upsert := false
+	switch mode := req.URL.Query().Get("mode"); mode {
+	case "", "insert":
+	case "upsert":
+		upsert = true
+	default:
+		writeError(w, http.StatusBadRequest, "invalid mode %q; want insert or upsert", mode)
+		return
+	}
+	dryRun, err := strconv.ParseBool(req.URL.Query().Get("dry_run"))
+	if err != nil && req.URL.Query().Get("dry_run") != "" {
+		writeError(w, http.StatusBadRequest, "invalid dry_run %q", req.URL.Query().Get("dry_run"))
+		return
+	}
+
+	body := http.MaxBytesReader(w, req.Body, maxImportSize)
+	var items []importItem
+	var errs []rowError
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		items, errs, err = readCSV(body)
+	case "", "application/json":
+		items, errs, err = readJSONItems(body)
+	default:
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported content type %q; want text/csv or application/json", mediaType)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	result := importResult{DryRun: dryRun, Errors: append([]rowError{}, errs...)}
+	seen := map[string]int{}
+	exists := make([]bool, len(items))
+	for i, item := range items {
+		if item.Name == "" {
+			continue // Already reported.
+		}
+		if row, ok := seen[item.Name]; ok {
+			result.Errors = append(result.Errors, rowError{i + 1, item.Name, fmt.Sprintf("duplicate of row %d", row)})
+			continue
+		}
+		seen[item.Name] = i + 1
+		_, err := s.Store.Get(fresh(req.Context()), item.Name)
+		switch {
+		case err == nil && !upsert:
+			result.Errors = append(result.Errors, rowError{i + 1, item.Name, conflict(item.Name).Error()})
+		case err == nil:
+			exists[i] = true
+		case !errors.Is(err, ErrNotFound):
+			s.storeError(w, req, err)
+			return
+		}
+	}
+	if len(result.Errors) > 0 {
+		sort.SliceStable(result.Errors, func(i, j int) bool { return result.Errors[i].Row < result.Errors[j].Row })
+		writeJSON(w, http.StatusUnprocessableEntity, result)
+		return
+	}
+	if dryRun {
+		for i := range items {
+			if exists[i] {
+				result.Updated++
+			} else {
+				result.Created++
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+
+	for i, item := range items {
+		created, err := s.put(req.Context(), item, upsert)
+		switch {
+		case err != nil:
+			result.Errors = append(result.Errors, rowError{i + 1, item.Name, err.Error()})
+		case created:
+			result.Created++
+		default:
+			result.Updated++
+		}
+	}
+	status := http.StatusOK
+	if len(result.Errors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, result)
+}
+
+// put creates item or, if upsert is set and it exists, changes the fields
+// the import gave, as PATCH would, reporting whether it was created.
+func (s *Server) put(ctx context.Context, item importItem, upsert bool) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		err := s.Store.Create(ctx, item.Item)
+		if err == nil || !upsert || !errors.Is(err, ErrConflict) {
+			return err == nil, err
+		}
+		current, err := s.Store.Get(fresh(ctx), item.Name)
+		if errors.Is(err, ErrNotFound) && attempt < maxRetries {
+			continue // Deleted since.
+		}
+		if err != nil {
+			return false, err
+		}
+		item.fields.apply(&current)
+		err = s.Store.Update(ctx, current)
+		if (errors.Is(err, ErrVersionMismatch) || errors.Is(err, ErrNotFound)) && attempt < maxRetries {
+			continue
+		}
+		return false, err
+	}
+}
+
+// An importItem is a row of an import: the item it creates, and the fields
+// it gave, which are all that it changes of an item that exists.
+type importItem struct {
+	Item
+	fields itemRequest
+}
+
+// readCSV reads the items in a CSV file. Columns left out leave the fields
+// of existing items as they are. Bad rows are reported as row errors, and
+// their items left with no name; a bad file is an error.
+func readCSV(r io.Reader) ([]importItem, []rowError, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("empty CSV file")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	column := map[string]int{}
+	for i, name := range header {
+		column[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range csvHeader[:2] {
+		if _, ok := column[name]; !ok {
+			return nil, nil, fmt.Errorf("CSV header has no %s column", name)
+		}
+	}
+
+	var items []importItem
+	var errs []rowError
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return items, errs, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		field := func(name string) string {
+			if i, ok := column[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		optional := func(name string) *string {
+			if _, ok := column[name]; !ok {
+				return nil
+			}
+			v := field(name)
+			return &v
+		}
+		name, price, code, quantity := field("name"), field("price"), field("currency"), field("quantity")
+		body := itemRequest{Name: &name, Category: optional("category"), Description: optional("description")}
+		if tags := optional("tags"); tags != nil {
+			fields := strings.Fields(*tags)
+			body.Tags = &fields
+		}
+		if code == "" {
+			code = DefaultCurrency
+		}
+		m, err := ParseMoney(price, code)
+		if err != nil {
+			errs = append(errs, rowError{row, name, fmt.Sprintf("invalid price %q: %v", price, err)})
+			items = append(items, importItem{})
+			continue
+		}
+		body.Price = &m
+		if quantity != "" {
+			n, err := strconv.ParseInt(quantity, 10, 64)
+			if err != nil {
+				errs = append(errs, rowError{row, name, fmt.Sprintf("invalid quantity %q", quantity)})
+				items = append(items, importItem{})
+				continue
+			}
+			body.Quantity = &n
+		}
+		items = append(items, importRow(row, body, &errs))
+	}
+}
+
+// readJSONItems reads the items in a JSON array, which may use any of the
+// forms of item accepted by POST /items. The fields that stores set are
+// allowed but ignored, so that an export can be imported.
+func readJSONItems(r io.Reader) ([]importItem, []rowError, error) {
+	var rows []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&rows); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, errors.New("empty request body")
+		}
+		return nil, nil, fmt.Errorf("invalid JSON body; want an array of items: %v", err)
+	}
+	var items []importItem
+	var errs []rowError
+	for i, raw := range rows {
+		var body struct {
+			itemRequest
+			Reserved json.RawMessage `json:"reserved"`
+			Version  json.RawMessage `json:"version"`
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			errs = append(errs, rowError{Row: i + 1, Message: fmt.Sprintf("invalid item: %v", err)})
+			items = append(items, importItem{})
+			continue
+		}
+		items = append(items, importRow(i+1, body.itemRequest, &errs))
+	}
+	return items, errs, nil
+}
+
+// importRow checks one row of an import, reporting any problem in errs and
+// returning an item with no name.
+func importRow(row int, body itemRequest, errs *[]rowError) importItem {
+	var name string
+	if body.Name != nil {
+		name = *body.Name
+	}
+	var err error
+	switch {
+	case !validName(name):
+		err = errors.New("missing or invalid name")
+	case body.Price == nil:
+		err = errors.New("missing price")
+	default:
+		err = body.validate()
+	}
+	if err != nil {
+		*errs = append(*errs, rowError{row, name, err.Error()})
+		return importItem{}
+	}
+	item := Item{Name: name}
+	body.apply(&item)
+	return importItem{item, body}
+}
+
+// exportItems handles "/items:export": GET writes every item as a JSON
+// array or, with ?format=csv, a CSV file that POST /items:import reads. It
+// reads the store a page at a time, so a large inventory is streamed.
+func (s *Server) exportItems(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	format := req.URL.Query().Get("format")
+	var write func(Item) error
+	var end func() error
+	switch format {
+	case "", "json":
+		format = "json"
+		w.Header().Set("Content-Type", "application/json")
+		first := true
+		write = func(item Item) error {
+			sep := ",\n"
+			if first {
+				sep, first = "[\n", false
+			}
+			data, err := json.Marshal(item)
+			if err == nil {
+				_, err = fmt.Fprintf(w, "%s%s", sep, data)
+			}
+			return err
+		}
+		end = func() error {
+			if first {
+				_, err := io.WriteString(w, "[]\n")
+				return err
+			}
+			_, err := io.WriteString(w, "\n]\n")
+			return err
+		}
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write(csvHeader)
+		write = func(item Item) error {
+			return cw.Write([]string{item.Name, item.Price.Decimal(), item.Price.Currency, strconv.FormatInt(item.Quantity, 10),
+				item.Category, strings.Join(item.Tags, " "), item.Description})
+		}
+		end = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "invalid format %q; want csv or json", format)
+		return
+	}
+
+	// Read the first page before committing to a successful response.
+	q := Query{Sort: SortName, Limit: MaxPageSize}
+	items, err := s.Store.Query(req.Context(), q)
+	if err != nil {
+		s.storeError(w, req, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="inventory.`+format+`"`)
+	if req.Method == http.MethodHead {
+		return
+	}
+	// Give each page the whole of the server's write timeout, however
+	// large the inventory.
+	rc := http.NewResponseController(w)
+	for {
+		rc.SetWriteDeadline(time.Now().Add(WriteTimeout))
+		for _, item := range items {
+			if err := write(item); err != nil {
+				log.Println("exporting items:", err)
+				return
+			}
+		}
+		if len(items) < q.Limit {
+			break
+		}
+		q.After = &items[len(items)-1]
+		if items, err = s.Store.Query(req.Context(), q); err != nil {
+			// The status is sent, so all that can be done is to cut the
+			// response short.
+			log.Println("exporting items:", err)
+			return
+		}
+	}
+	if err := end(); err != nil {
+		log.Println("exporting items:", err)
+	}
+}
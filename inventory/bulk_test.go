@@ -0,0 +1,149 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func post(t *testing.T, ts *httptest.Server, path, contentType, body string) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.Post(ts.URL+path, contentType, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func TestImport(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, false)
+	const csvFile = "Name,Price,Currency,Quantity\nhats,19.99,,5\nbelts,12,EUR,\n"
+	tests := []struct {
+		path, contentType, body string
+		status                  int
+		want                    string
+	}{
+		{"/items:import?dry_run=true", "text/csv", csvFile, 200,
+			`{"dry_run":true,"created":2,"updated":0,"errors":[]}`},
+		{"/items:import", "text/csv", "price,name\n1,shoes\nx,gloves\n2,/\n3,hats\n3,hats\n", 422,
+			`{"dry_run":false,"created":0,"updated":0,"errors":[` +
+				`{"row":1,"name":"shoes","message":"item already exists: \"shoes\""},` +
+				`{"row":2,"name":"gloves","message":"invalid price \"x\": invalid amount \"x\""},` +
+				`{"row":3,"name":"/","message":"missing or invalid name"},` +
+				`{"row":5,"name":"hats","message":"duplicate of row 4"}]}`},
+		{"/items:import", "text/csv", csvFile, 200,
+			`{"dry_run":false,"created":2,"updated":0,"errors":[]}`},
+		{"/items:import", "application/json", `[{"name":"gloves","price":"3.50","quantity":-1},{"name":"hats","price":1},{"nom":"x"}]`, 422,
+			`{"dry_run":false,"created":0,"updated":0,"errors":[` +
+				`{"row":1,"name":"gloves","message":"quantity must not be negative, got -1"},` +
+				`{"row":2,"name":"hats","message":"item already exists: \"hats\""},` +
+				`{"row":3,"message":"invalid item: json: unknown field \"nom\""}]}`},
+		{"/items:import?mode=upsert", "application/json", `[{"name":"gloves","price":"3.50"},{"name":"hats","price":1,"quantity":7}]`, 200,
+			`{"dry_run":false,"created":1,"updated":1,"errors":[]}`},
+		{"/items:import", "application/json", `{"name":"gloves"}`, 400, ""},
+		{"/items:import", "text/csv", "name\nhats\n", 400, `{"error":{"status":400,"message":"CSV header has no price column"}}`},
+		{"/items:import", "text/plain", "", 415, ""},
+		{"/items:import?mode=replace", "text/csv", csvFile, 400, ""},
+	}
+	for _, tt := range tests {
+		resp, body := post(t, ts, tt.path, tt.contentType, tt.body)
+		if resp.StatusCode != tt.status || tt.want != "" && body != tt.want+"\n" {
+			t.Errorf("POST %s %q = %d %s, want %d %s", tt.path, tt.body, resp.StatusCode, body, tt.status, tt.want)
+		}
+	}
+
+	_, body := do(t, ts, "GET", "/items/hats", "")
+	if want := `{"name":"hats","price":{"amount":"1.00","currency":"USD"},"quantity":7,"reserved":0,"version":2}` + "\n"; body != want {
+		t.Errorf("after upsert got %s, want %s", body, want)
+	}
+	if resp, _ := do(t, ts, "GET", "/items:import", ""); resp.StatusCode != 405 {
+		t.Errorf("GET /items:import: status %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestImportUpsertPartial(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := NewMemoryStore(Item{Name: "hats", Price: Money{1999, "USD"}, Quantity: 10, Category: "hats", Tags: []string{"warm", "wool"}, Description: "Knitted"})
+	if _, err := store.Reserve(ctx, []Line{{"hats", 4}}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer((&Server{Store: store}).Handler())
+	t.Cleanup(ts.Close)
+
+	// Columns and fields left out keep their values, so stock is not lost
+	// and the reservation still fits.
+	for _, file := range []struct{ contentType, body string }{
+		{"text/csv", "name,price\nhats,25\n"},
+		{"text/csv", "name,price,tags\nhats,25,warm wool\n"},
+		{"application/json", `[{"name":"hats","price":25}]`},
+	} {
+		resp, body := post(t, ts, "/items:import?mode=upsert", file.contentType, file.body)
+		if want := `{"dry_run":false,"created":0,"updated":1,"errors":[]}` + "\n"; resp.StatusCode != 200 || body != want {
+			t.Errorf("upserting %q = %d %s, want 200 %s", file.body, resp.StatusCode, body, want)
+		}
+		item, err := store.Get(ctx, "hats")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Price != (Money{2500, "USD"}) || item.Quantity != 10 || item.Reserved != 4 || item.Category != "hats" ||
+			strings.Join(item.Tags, " ") != "warm wool" || item.Description != "Knitted" {
+			t.Errorf("after upserting %q, item = %+v", file.body, item)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := NewMemoryStore()
+	// More items than fit in a page of the store.
+	for i := 0; i < MaxPageSize+10; i++ {
+		store.Create(ctx, Item{Name: fmt.Sprintf("item%04d", i), Price: Money{int64(i), "EUR"}, Quantity: 1})
+	}
+	store.Update(ctx, Item{Name: "item0001", Price: Money{1, "EUR"}, Quantity: 1, Category: "hats", Tags: []string{"warm", "wool"}, Description: "Knitted, with a bobble"})
+	ts := httptest.NewServer((&Server{Store: store}).Handler())
+	t.Cleanup(ts.Close)
+
+	resp, csvFile := do(t, ts, "GET", "/items:export?format=csv", "")
+	lines := strings.Split(strings.TrimSuffix(csvFile, "\n"), "\n")
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/csv; charset=utf-8" ||
+		len(lines) != MaxPageSize+11 || lines[0] != "name,price,currency,quantity,category,tags,description" || lines[2] != `item0001,0.01,EUR,1,hats,warm wool,"Knitted, with a bobble"` {
+		t.Fatalf("CSV export = %d, %d lines starting %q", resp.StatusCode, len(lines), lines[:3])
+	}
+
+	resp, jsonFile := do(t, ts, "GET", "/items:export", "")
+	var items []Item
+	if err := json.Unmarshal([]byte(jsonFile), &items); err != nil || len(items) != MaxPageSize+10 || items[MaxPageSize+9].Name != "item1009" {
+		t.Fatalf("JSON export = %d, %v, %d items", resp.StatusCode, err, len(items))
+	}
+
+	// Either export imports into an empty store.
+	for _, file := range []struct{ contentType, body string }{{"text/csv", csvFile}, {"application/json", jsonFile}} {
+		ts := newTestServer(t, false)
+		if resp, body := post(t, ts, "/items:import", file.contentType, file.body); resp.StatusCode != 200 {
+			t.Errorf("importing the %s export: %d %.200s", file.contentType, resp.StatusCode, body)
+		}
+	}
+
+	if resp, body := do(t, newTestServer(t, false), "GET", "/items:export?format=xml", ""); resp.StatusCode != 400 {
+		t.Errorf("GET /items:export?format=xml = %d %s", resp.StatusCode, body)
+	}
+	empty := httptest.NewServer((&Server{Store: NewMemoryStore()}).Handler())
+	t.Cleanup(empty.Close)
+	if _, body := do(t, empty, "GET", "/items:export", ""); body != "[]\n" {
+		t.Errorf("exporting nothing gave %q", body)
+	}
+}
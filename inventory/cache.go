@@ -0,0 +1,210 @@
+package inventory
+
+import (
+	"container/list"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// A Cache is a store that keeps the items Get reads from another in
+// memory for a time, dropping the least recently used beyond its size.
+// Changes made through the cache drop the items they touch. Changes made
+// by other servers sharing the store are only seen once their items
+// expire, unless the cache Follows the store; so are reservations that
+// expire, which change the quantity items have reserved. The server reads
+// around the cache where a stale version would be wrong: for conditional
+// requests and before changing an item.
+type Cache struct {
+	InventoryStore
+	size    int
+	ttl     time.Duration
+	metrics *Metrics // Counts hits and misses, if not nil.
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Of *cacheEntry, most recently used first.
+	gen     uint64     // Incremented by every invalidation, so Get does not keep items read before one.
+}
+
+type cacheEntry struct {
+	item    Item
+	expires time.Time
+}
+
+// NewCache returns a cache of up to size items of store, each kept for
+// ttl, with its hits and misses counted in m if it is not nil.
+func NewCache(store InventoryStore, size int, ttl time.Duration, m *Metrics) *Cache {
+	return &Cache{InventoryStore: store, size: size, ttl: ttl, metrics: m, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+type freshKey struct{}
+
+// fresh returns ctx marked so that a Cache reads items from its store,
+// keeping what it reads, rather than answering from memory.
+func fresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshKey{}, true)
+}
+
+func (c *Cache) Get(ctx context.Context, name string) (Item, error) {
+	if ctx.Value(freshKey{}) != nil {
+		c.mu.Lock()
+		gen := c.gen
+		c.mu.Unlock()
+		return c.load(ctx, name, gen)
+	}
+	c.mu.Lock()
+	if e, ok := c.entries[name]; ok {
+		entry := e.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.lru.MoveToFront(e)
+			item := entry.item
+			c.mu.Unlock()
+			c.metrics.cacheLookup(true)
+			item.Tags = slices.Clone(item.Tags)
+			return item, nil
+		}
+		c.lru.Remove(e)
+		delete(c.entries, name)
+	}
+	gen := c.gen
+	c.mu.Unlock()
+	c.metrics.cacheLookup(false)
+	return c.load(ctx, name, gen)
+}
+
+// load reads the named item from the store, keeping it unless the cache
+// has been invalidated since gen.
+func (c *Cache) load(ctx context.Context, name string, gen uint64) (Item, error) {
+	item, err := c.InventoryStore.Get(ctx, name)
+	if err != nil {
+		return item, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen == gen {
+		c.add(item)
+	}
+	return item, nil
+}
+
+// add keeps item, dropping the least recently used item if the cache is
+// full. The caller must hold c.mu.
+func (c *Cache) add(item Item) {
+	if c.size <= 0 {
+		return
+	}
+	item.Tags = slices.Clone(item.Tags)
+	entry := &cacheEntry{item, time.Now().Add(c.ttl)}
+	if e, ok := c.entries[item.Name]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[item.Name] = c.lru.PushFront(entry)
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).item.Name)
+	}
+}
+
+// Len returns the number of items in the cache, including any that have
+// expired but not yet been dropped.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Invalidate drops the named items, so that they are read from the store
+// when next asked for.
+func (c *Cache) Invalidate(names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for _, name := range names {
+		if e, ok := c.entries[name]; ok {
+			c.lru.Remove(e)
+			delete(c.entries, name)
+		}
+	}
+}
+
+// Purge drops every item.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	clear(c.entries)
+	c.lru.Init()
+}
+
+// Follow drops items as Watch reports changes to them, including those
+// made by other servers sharing the store, until ctx is done or Watch
+// fails. Either way it then purges the cache, as changes may have been
+// missed, and returns the error from Watch.
+func (c *Cache) Follow(ctx context.Context) error {
+	err := c.InventoryStore.Watch(ctx, func(e Event) { c.Invalidate(e.Item) })
+	c.Purge()
+	return err
+}
+
+func (c *Cache) Create(ctx context.Context, item Item) error {
+	defer c.Invalidate(item.Name)
+	return c.InventoryStore.Create(ctx, item)
+}
+
+func (c *Cache) Update(ctx context.Context, item Item) error {
+	defer c.Invalidate(item.Name)
+	return c.InventoryStore.Update(ctx, item)
+}
+
+func (c *Cache) Delete(ctx context.Context, name string, version int64) error {
+	defer c.Invalidate(name)
+	return c.InventoryStore.Delete(ctx, name, version)
+}
+
+func (c *Cache) Reserve(ctx context.Context, lines []Line, expires time.Time) (Reservation, error) {
+	defer c.Invalidate(lineItems(lines)...)
+	return c.InventoryStore.Reserve(ctx, lines, expires)
+}
+
+// Release purges the cache, as the items of the reservation are not known
+// without reading it.
+func (c *Cache) Release(ctx context.Context, id string) error {
+	defer c.Purge()
+	return c.InventoryStore.Release(ctx, id)
+}
+
+func (c *Cache) PlaceOrder(ctx context.Context, lines []Line, id string) (Order, error) {
+	o, err := c.InventoryStore.PlaceOrder(ctx, lines, id)
+	switch {
+	case err != nil && id != "":
+		// The reservation's items are not known.
+		c.Purge()
+	case err != nil:
+		c.Invalidate(lineItems(lines)...)
+	default:
+		names := make([]string, len(o.Lines))
+		for i, l := range o.Lines {
+			names[i] = l.Item
+		}
+		c.Invalidate(names...)
+	}
+	return o, err
+}
+
+func (c *Cache) Ping(ctx context.Context) error {
+	return ping(ctx, c.InventoryStore)
+}
+
+// lineItems returns the names of the items in lines.
+func lineItems(lines []Line) []string {
+	names := make([]string, len(lines))
+	for i, l := range lines {
+		names[i] = l.Item
+	}
+	return names
+}
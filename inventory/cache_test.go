@@ -0,0 +1,205 @@
+package inventory
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStore is a store that counts the items read from it.
+type countingStore struct {
+	InventoryStore
+	gets atomic.Int64
+}
+
+func (s *countingStore) Get(ctx context.Context, name string) (Item, error) {
+	s.gets.Add(1)
+	return s.InventoryStore.Get(ctx, name)
+}
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := &countingStore{InventoryStore: NewMemoryStore(DefaultItems...)}
+	c := NewCache(store, 2, time.Hour, nil)
+
+	get := func(name string, wantGets int64) Item {
+		t.Helper()
+		item, err := c.Get(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := store.gets.Load(); got != wantGets {
+			t.Errorf("Get(%q): store read %d times, want %d", name, got, wantGets)
+		}
+		return item
+	}
+	get("shoes", 1)
+	get("shoes", 1)
+	get("socks", 2)
+
+	// Changes through the cache are seen at once.
+	shoes := get("shoes", 2)
+	shoes.Price = Money{4500, "USD"}
+	if err := c.Update(ctx, shoes); err != nil {
+		t.Fatal(err)
+	}
+	if got := get("shoes", 3); got.Price != shoes.Price || got.Version != 2 {
+		t.Errorf("after Update, Get = %+v", got)
+	}
+	if _, err := c.Reserve(ctx, []Line{{"shoes", 3}}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got := get("shoes", 4); got.Reserved != 3 {
+		t.Errorf("after Reserve, reserved = %d, want 3", got.Reserved)
+	}
+	if err := c.Delete(ctx, "socks", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "socks"); err == nil {
+		t.Error("Get of deleted item succeeded")
+	}
+
+	// The least recently used item is dropped beyond the size.
+	for _, name := range []string{"a", "b"} {
+		if err := c.Create(ctx, Item{Name: name, Price: Money{100, "USD"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gets := store.gets.Load()
+	get("a", gets+1)
+	get("b", gets+2)
+	get("a", gets+2)
+	get("shoes", gets+3)
+	get("a", gets+3)
+	get("b", gets+4)
+	if n := c.Len(); n != 2 {
+		t.Errorf("Len = %d, want 2", n)
+	}
+}
+
+// TestCacheReplicas runs two servers, each with a cache, over one store, as
+// replicas sharing MongoDB do.
+func TestCacheReplicas(t *testing.T) {
+	t.Parallel()
+	store := NewMemoryStore(DefaultItems...)
+	var replicas [2]*httptest.Server
+	for i := range replicas {
+		replicas[i] = httptest.NewServer((&Server{Store: NewCache(store, 10, time.Hour, nil)}).Handler())
+		t.Cleanup(replicas[i].Close)
+	}
+	a, b := replicas[0], replicas[1]
+
+	tests := []struct {
+		ts                         *httptest.Server
+		method, path, header, body string
+		status                     int
+		etag                       string
+	}{
+		{a, "GET", "/items/shoes", "", "", 200, `"1"`}, // Cached by a.
+		{b, "PATCH", "/items/shoes", "", `{"quantity":5}`, 200, `"2"`},
+		{b, "GET", "/items/shoes", "", "", 200, `"2"`}, // Cached by b.
+		{a, "GET", "/items/shoes", `If-None-Match: "1"`, "", 200, `"2"`},
+		{a, "PATCH", "/items/shoes", `If-Match: "2"`, `{"quantity":6}`, 200, `"3"`},
+		{b, "GET", "/items/shoes", "", "", 200, `"2"`}, // Stale, until it expires.
+		{b, "GET", "/items/shoes", `If-None-Match: "3"`, "", 304, `"3"`},
+		{b, "PUT", "/items/shoes", `If-Match: "3"`, `{"price":45}`, 200, `"4"`},
+		{a, "DELETE", "/items/shoes", `If-Match: "4"`, "", 204, ""},
+		{b, "GET", "/items/shoes", "", "", 404, ""},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, tt.ts.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name, value, ok := strings.Cut(tt.header, ": "); ok {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		replica := "a"
+		if tt.ts == b {
+			replica = "b"
+		}
+		if resp.StatusCode != tt.status || resp.Header.Get("ETag") != tt.etag {
+			t.Errorf("%s %s on %s with %s: got %d, ETag %s; want %d, ETag %s",
+				tt.method, tt.path, replica, tt.header, resp.StatusCode, resp.Header.Get("ETag"), tt.status, tt.etag)
+		}
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := &countingStore{InventoryStore: NewMemoryStore(DefaultItems...)}
+	c := NewCache(store, 10, 10*time.Millisecond, nil)
+	c.Get(ctx, "shoes")
+	c.Get(ctx, "shoes")
+	time.Sleep(20 * time.Millisecond)
+	c.Get(ctx, "shoes")
+	if got := store.gets.Load(); got != 2 {
+		t.Errorf("store read %d times, want 2", got)
+	}
+}
+
+func TestCacheFollow(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	store := NewMemoryStore(DefaultItems...)
+	c := NewCache(store, 10, time.Hour, nil)
+	done := make(chan error)
+	go func() { done <- c.Follow(ctx) }()
+
+	// Another server changes the store; keep at it until Follow is
+	// watching.
+	for i := int64(1); ; i++ {
+		if _, err := c.Get(ctx, "shoes"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Update(ctx, Item{Name: "shoes", Price: Money{i, "USD"}, Quantity: 10}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+		if item, _ := c.Get(ctx, "shoes"); item.Price.Amount == i {
+			break
+		}
+		if i == 1000 {
+			t.Fatal("cache never saw the change")
+		}
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Follow = %v, want %v", err, context.Canceled)
+	}
+	if n := c.Len(); n != 0 {
+		t.Errorf("after Follow, Len = %d, want 0", n)
+	}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	t.Parallel()
+	m := NewMetrics()
+	ts := httptest.NewServer((&Server{Store: NewCache(NewMemoryStore(DefaultItems...), 10, time.Hour, m), Metrics: m, Legacy: true}).Handler())
+	t.Cleanup(ts.Close)
+
+	for i := 0; i < 3; i++ {
+		do(t, ts, "GET", "/price?item=shoes", "")
+	}
+	_, body := do(t, ts, "GET", "/metrics", "")
+	for _, want := range []string{
+		`inventory_cache_lookups_total{result="hit"} 2`,
+		`inventory_cache_lookups_total{result="miss"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+}
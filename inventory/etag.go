@@ -0,0 +1,121 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxRetries is how many times modify tries again when another client
+// changes an item between its read and its write.
+const maxRetries = 5
+
+// etag returns the entity tag of an item at version. The reserved count is
+// not part of it, as reservations do not change the version.
+func etag(version int64) string { return `"` + strconv.FormatInt(version, 10) + `"` }
+
+// matchETag reports whether header, the value of an If-Match or
+// If-None-Match header, lists the tag of version or is "*". Weak tags
+// only match when weak is set, as If-None-Match allows.
+func matchETag(header string, version int64, weak bool) bool {
+	want := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == want {
+			return true
+		}
+	}
+	return false
+}
+
+// current reads the named item for a change, checking it against the
+// request's If-Match header. It reads around any Cache, as a stale version
+// would fail the check or the write. If the item does not exist the header
+// cannot match, so that is a version mismatch too.
+func (s *Server) current(req *http.Request, name string) (Item, error) {
+	header := req.Header.Get("If-Match")
+	item, err := s.Store.Get(fresh(req.Context()), name)
+	switch {
+	case header == "" || err != nil && !errors.Is(err, ErrNotFound):
+		return item, err
+	case err != nil:
+		return item, fmt.Errorf("%w: %q does not exist", ErrVersionMismatch, name)
+	case !matchETag(header, item.Version, false):
+		return item, fmt.Errorf("%w: %q is at version %d", ErrVersionMismatch, name, item.Version)
+	}
+	return item, nil
+}
+
+// modify reads the named item, changes it with fn and writes it back,
+// returning the item as written. The write only succeeds if nobody has
+// changed the item since it was read; if somebody has, modify reads it
+// again, checking If-Match against the new version.
+func (s *Server) modify(req *http.Request, name string, fn func(*Item)) (Item, error) {
+	for attempt := 0; ; attempt++ {
+		item, err := s.current(req, name)
+		if err != nil {
+			return Item{}, err
+		}
+		fn(&item)
+		err = s.Store.Update(req.Context(), item)
+		if errors.Is(err, ErrVersionMismatch) && attempt < maxRetries {
+			continue
+		}
+		if err != nil {
+			return Item{}, err
+		}
+		item.Version++
+		return item, nil
+	}
+}
+
+// upsert is modify for PUT, which also creates the named item if it does
+// not exist, unless If-Match requires that it does. With If-None-Match: *
+// it only creates the item. It reports whether the item was created.
+func (s *Server) upsert(req *http.Request, name string, fn func(*Item)) (Item, bool, error) {
+	createOnly := req.Header.Get("If-None-Match") == "*"
+	for attempt := 0; ; attempt++ {
+		if !createOnly {
+			item, err := s.modify(req, name, fn)
+			if !errors.Is(err, ErrNotFound) || req.Header.Get("If-Match") != "" {
+				return item, false, err
+			}
+		}
+		item := Item{Name: name}
+		fn(&item)
+		err := s.Store.Create(req.Context(), item)
+		switch {
+		case errors.Is(err, ErrConflict) && createOnly:
+			return Item{}, false, fmt.Errorf("%w: %q exists", ErrVersionMismatch, name)
+		case errors.Is(err, ErrConflict) && attempt < maxRetries:
+			continue // Created since it was found missing.
+		case err != nil:
+			return Item{}, false, err
+		}
+		item.Version = 1
+		return item, true, nil
+	}
+}
+
+// remove deletes the named item, like modify.
+func (s *Server) remove(req *http.Request, name string) error {
+	for attempt := 0; ; attempt++ {
+		item, err := s.current(req, name)
+		if err != nil {
+			return err
+		}
+		err = s.Store.Delete(req.Context(), name, item.Version)
+		if errors.Is(err, ErrVersionMismatch) && attempt < maxRetries {
+			continue
+		}
+		return err
+	}
+}
@@ -0,0 +1,242 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultEventBuffer = 1000             // Events a broker keeps for clients resuming a stream.
+	subscriberBuffer   = 64               // Events queued for a slow subscriber before it is dropped.
+	heartbeatInterval  = 15 * time.Second // Between comments that keep idle streams open through proxies.
+	retryDelay         = time.Second      // Before watching a store again after an error; also sent to clients.
+)
+
+// A published event is an Event with the ID a broker gave it.
+type published struct {
+	id int64
+	Event
+}
+
+// A subscriber receives the events about the items it asked for, or every
+// event if it asked for none.
+type subscriber struct {
+	items map[string]bool
+	ch    chan published
+}
+
+func (sub *subscriber) wants(e Event) bool { return len(sub.items) == 0 || sub.items[e.Item] }
+
+// A Broker passes the changes made to a store on to the clients of
+// GET /events. It keeps the latest events so that a client that loses its
+// connection can resume where it left off, and drops a client that falls
+// too far behind, which can then resume the same way.
+//
+// Event IDs are only meaningful to the broker that gave them. A client
+// resuming from an event the broker no longer has, or from another broker
+// such as one from before a restart, is sent a reset event instead, after
+// which it should fetch the items again.
+type Broker struct {
+	mu     sync.Mutex
+	epoch  string      // Distinguishes this broker's IDs from those of others.
+	next   int64       // ID of the next event.
+	buffer []published // The latest events, oldest first.
+	size   int
+	subs   map[*subscriber]bool
+	closed bool
+}
+
+// NewBroker returns a broker keeping the latest size events.
+func NewBroker(size int) *Broker {
+	return &Broker{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		next:  1,
+		size:  size,
+		subs:  map[*subscriber]bool{},
+	}
+}
+
+// Run passes the changes made to store on to the broker's clients until
+// ctx is done, watching the store again if it fails.
+func (b *Broker) Run(ctx context.Context, store InventoryStore) {
+	for {
+		err := store.Watch(ctx, b.Publish)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("watching the store:", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// Publish sends e to the subscribers that want it. It never blocks.
+func (b *Broker) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p := published{b.next, e}
+	b.next++
+	if b.size > 0 {
+		if len(b.buffer) == b.size {
+			copy(b.buffer, b.buffer[1:])
+			b.buffer = b.buffer[:b.size-1]
+		}
+		b.buffer = append(b.buffer, p)
+	}
+	for sub := range b.subs {
+		if !sub.wants(e) {
+			continue
+		}
+		select {
+		case sub.ch <- p:
+		default:
+			delete(b.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// subscribe registers a subscriber to the events about items, returning
+// with it the buffered events after lastID, if given, and whether events
+// since lastID have been lost.
+func (b *Broker) subscribe(items []string, lastID string) (sub *subscriber, backlog []published, reset bool) {
+	sub = &subscriber{items: map[string]bool{}, ch: make(chan published, subscriberBuffer)}
+	for _, name := range items {
+		sub.items[name] = true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.ch)
+		return sub, nil, false
+	}
+	b.subs[sub] = true
+	if lastID == "" {
+		return sub, nil, false
+	}
+	last, ok := b.parseID(lastID)
+	oldest := b.next
+	if len(b.buffer) > 0 {
+		oldest = b.buffer[0].id
+	}
+	if !ok || last < oldest-1 || last >= b.next {
+		return sub, nil, true
+	}
+	for _, p := range b.buffer[last-oldest+1:] {
+		if sub.wants(p.Event) {
+			backlog = append(backlog, p)
+		}
+	}
+	return sub, backlog, false
+}
+
+// unsubscribe removes sub, if it has not already been dropped.
+func (b *Broker) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Close ends every stream, and any started later, so that a server can shut
+// down without waiting for them. Clients reconnect, to another server if
+// there is one, and resume.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+func (b *Broker) formatID(id int64) string { return b.epoch + "-" + strconv.FormatInt(id, 10) }
+
+func (b *Broker) parseID(s string) (int64, bool) {
+	epoch, n, _ := strings.Cut(s, "-")
+	id, err := strconv.ParseInt(n, 10, 64)
+	return id, epoch == b.epoch && err == nil
+}
+
+// events handles "/events": GET streams the changes made to the inventory
+// as Server-Sent Events, named after their op, with the Event as data. Any
+// number of item parameters, each a name or a comma-separated list,
+// restricts the stream to those items. A Last-Event-ID header resumes a
+// stream after the event with that ID.
+func (s *Server) events(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	var items []string
+	for _, v := range req.URL.Query()["item"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				items = append(items, name)
+			}
+		}
+	}
+	sub, backlog, reset := s.Events.subscribe(items, req.Header.Get("Last-Event-ID"))
+	defer s.Events.unsubscribe(sub)
+
+	// The server's write timeout would end the stream, so each write gets
+	// its own deadline instead.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(2 * heartbeatInterval))
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Stop nginx holding events back.
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", retryDelay.Milliseconds())
+	if reset {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, p := range backlog {
+		s.Events.write(w, p)
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case p, ok := <-sub.ch:
+			if !ok {
+				return // Dropped for falling behind, or closed; the client resumes from the last event it got.
+			}
+			s.Events.write(w, p)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		rc.SetWriteDeadline(time.Now().Add(2 * heartbeatInterval))
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// write sends p as a Server-Sent Event.
+func (b *Broker) write(w http.ResponseWriter, p published) {
+	data, err := json.Marshal(p.Event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", b.formatID(p.id), p.Op, data)
+}
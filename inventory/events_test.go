@@ -0,0 +1,158 @@
+package inventory
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBroker(t *testing.T) {
+	t.Parallel()
+	b := NewBroker(3)
+	for _, name := range []string{"shoes", "socks", "shoes", "hats"} {
+		b.Publish(Event{Item: name, Op: OpUpdate})
+	}
+	ids := func(ps []published) (s []int64) {
+		for _, p := range ps {
+			s = append(s, p.id)
+		}
+		return s
+	}
+
+	// The buffer holds events 2 to 4.
+	tests := []struct {
+		items  []string
+		lastID string
+		want   []int64
+		reset  bool
+	}{
+		{nil, "", nil, false},
+		{nil, b.formatID(2), []int64{3, 4}, false},
+		{[]string{"shoes"}, b.formatID(1), []int64{3}, false},
+		{[]string{"shoes", "socks"}, b.formatID(1), []int64{2, 3}, false},
+		{nil, b.formatID(4), nil, false},
+		{nil, b.formatID(0), nil, true}, // Event 1 is no longer buffered.
+		{nil, b.formatID(5), nil, true},
+		{nil, "1-2", nil, true},
+		{nil, "bogus", nil, true},
+	}
+	for _, tt := range tests {
+		sub, backlog, reset := b.subscribe(tt.items, tt.lastID)
+		if got := ids(backlog); !reflect.DeepEqual(got, tt.want) || reset != tt.reset {
+			t.Errorf("subscribe(%v, %q) = %v, %v; want %v, %v", tt.items, tt.lastID, got, reset, tt.want, tt.reset)
+		}
+		b.unsubscribe(sub)
+	}
+
+	// A subscriber that falls behind is dropped once its queue is full.
+	slow, _, _ := b.subscribe([]string{"shoes"}, "")
+	for i := 0; i < subscriberBuffer+1; i++ {
+		b.Publish(Event{Item: "shoes", Op: OpUpdate})
+	}
+	n := 0
+	for range slow.ch {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("slow subscriber got %d events before being dropped, want %d", n, subscriberBuffer)
+	}
+	b.unsubscribe(slow)
+}
+
+// stream reads Server-Sent Events.
+type stream struct {
+	*bufio.Scanner
+}
+
+// next returns the fields of the next event, skipping comments.
+func (s stream) next(t *testing.T) map[string]string {
+	t.Helper()
+	fields := map[string]string{}
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			if len(fields) > 0 {
+				return fields
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		name, value, _ := strings.Cut(line, ": ")
+		fields[name] = value
+	}
+	t.Fatalf("stream ended: %v", s.Err())
+	return nil
+}
+
+func TestEventsAPI(t *testing.T) {
+	t.Parallel()
+	store := NewMemoryStore(DefaultItems...)
+	events := NewBroker(DefaultEventBuffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go events.Run(ctx, store)
+	for watching := false; !watching; time.Sleep(time.Millisecond) {
+		store.mu.RLock()
+		watching = len(store.state.watchers) > 0
+		store.mu.RUnlock()
+	}
+	ts := httptest.NewServer((&Server{Store: store, Events: events}).Handler())
+	t.Cleanup(ts.Close)
+
+	subscribe := func(path, lastID string) stream {
+		t.Helper()
+		req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+path, nil)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("GET %s: %s, %s", path, resp.Status, resp.Header.Get("Content-Type"))
+		}
+		s := stream{bufio.NewScanner(resp.Body)}
+		if f := s.next(t); f["retry"] == "" {
+			t.Fatalf("stream starts with %v, want retry", f)
+		}
+		return s
+	}
+
+	s := subscribe("/events?item=shoes", "")
+	do(t, ts, "PATCH", "/items/socks", `{"price":6}`)
+	do(t, ts, "PATCH", "/items/shoes", `{"price":60}`)
+	f := s.next(t)
+	var e Event
+	if err := json.Unmarshal([]byte(f["data"]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if f["event"] != OpUpdate || e.Item != "shoes" || e.Old.Price.Amount != 5000 || e.New.Price.Amount != 6000 {
+		t.Errorf("got event %v", f)
+	}
+
+	// Resuming after that event sends what happened since.
+	do(t, ts, "DELETE", "/items/shoes", "")
+	do(t, ts, "DELETE", "/items/socks", "")
+	s = subscribe("/events?item=socks,hats", f["id"])
+	if f := s.next(t); f["event"] != OpDelete || !strings.Contains(f["data"], `"item":"socks"`) {
+		t.Errorf("resumed stream starts with %v, want the deletion of socks", f)
+	}
+	s = subscribe("/events", "0-1")
+	if f := s.next(t); f["event"] != "reset" {
+		t.Errorf("stream resumed from an unknown event starts with %v, want reset", f)
+	}
+
+	if resp, _ := do(t, ts, "POST", "/events", ""); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /events: status %d, want 405", resp.StatusCode)
+	}
+}
@@ -0,0 +1,389 @@
+package inventory
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	snapshotFile  = "snapshot.json"
+	walFile       = "wal.log"
+	snapshotEvery = 1000    // Log records appended between snapshots.
+	maxRecordSize = 1 << 20 // Largest log record replayed; anything bigger is corrupt.
+	headerSize    = 8       // Record length and CRC-32, both big-endian uint32.
+)
+
+// A walRecord is one change appended to the write-ahead log.
+type walRecord struct {
+	change
+
+	// Records written before reservations existed hold a single put or
+	// delete, of an item without a version.
+	Op   string `json:"op,omitempty"`
+	Name string `json:"name,omitempty"`
+	Item *Item  `json:"item,omitempty"`
+}
+
+// A snapshot holds everything in a file store.
+type snapshot struct {
+	Version      int           `json:"version"`
+	Items        []Item        `json:"items"`
+	Reservations []Reservation `json:"reservations"`
+	Events       []Event       `json:"events,omitempty"` // In order.
+}
+
+// A FileStore keeps items and reservations in memory and makes every
+// change durable by appending it to a write-ahead log before applying it.
+// Every snapshotEvery records it writes everything to a snapshot and
+// empties the log, so that startup only replays the changes since.
+//
+// Each log record is its length, the CRC-32 of its JSON payload, then the
+// payload. A crash can leave a torn final record, which replay discards;
+// a bad record anywhere else is reported as corruption.
+type FileStore struct {
+	mu            sync.RWMutex
+	dir           string
+	state         *state
+	wal           *os.File
+	fresh         bool
+	appended      int // Records in the log since the last snapshot.
+	snapshotEvery int
+}
+
+// OpenFileStore loads the snapshot and replays the log in dir, creating
+// the directory if necessary.
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &FileStore{dir: dir, state: newState(), fresh: true, snapshotEvery: snapshotEvery}
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	switch {
+	case err == nil:
+		s.fresh = false
+		if err := s.loadSnapshot(data); err != nil {
+			return nil, fmt.Errorf("reading snapshot: %v", err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+
+	s.wal, err = os.OpenFile(filepath.Join(dir, walFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		s.wal.Close()
+		return nil, err
+	}
+	if s.appended > 0 {
+		s.fresh = false
+	}
+	return s, nil
+}
+
+// loadSnapshot reads a snapshot, or the map of items written before
+// reservations existed.
+func (s *FileStore) loadSnapshot(data []byte) error {
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil || snap.Version == 0 {
+		var items map[string]Item
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		for _, item := range items {
+			item.Version = 1
+			s.state.put(item)
+		}
+		return nil
+	}
+	for _, item := range snap.Items {
+		s.state.put(item)
+	}
+	for _, r := range snap.Reservations {
+		s.state.reservations[r.ID] = r
+	}
+	for _, e := range snap.Events {
+		s.state.record(e)
+	}
+	return nil
+}
+
+// Fresh reports whether the store was created, rather than loaded, by
+// OpenFileStore, and so needs seeding.
+func (s *FileStore) Fresh() bool { return s.fresh }
+
+// replay applies the records in the log to the snapshot, truncating a torn
+// final record and leaving the file positioned for appending.
+func (s *FileStore) replay() error {
+	info, err := s.wal.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	r := bufio.NewReader(s.wal)
+	var offset int64
+	for offset < size {
+		rec, n, err := readRecord(r)
+		if err != nil {
+			// Only the last write can have been interrupted, so a bad
+			// record must reach the end of the file to be a torn one.
+			if offset+n < size && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("%s: corrupt record at offset %d: %v", walFile, offset, err)
+			}
+			log.Printf("%s: discarding torn record at offset %d: %v", walFile, offset, err)
+			if err := s.wal.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		s.state.apply(rec.change)
+		s.appended++
+		offset += n
+	}
+	_, err = s.wal.Seek(offset, io.SeekStart)
+	return err
+}
+
+// readRecord reads one record and reports how many bytes it spans.
+func readRecord(r io.Reader) (walRecord, int64, error) {
+	var rec walRecord
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return rec, 0, io.ErrUnexpectedEOF
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	n := headerSize + int64(length)
+	if length > maxRecordSize {
+		return rec, n, fmt.Errorf("record length %d exceeds %d", length, maxRecordSize)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return rec, n, io.ErrUnexpectedEOF
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return rec, n, errors.New("checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, n, err
+	}
+	switch {
+	case rec.Op == "put" && rec.Item != nil:
+		rec.Item.Version = 1
+		rec.change = change{Put: []Item{*rec.Item}}
+	case rec.Op == "delete":
+		rec.change = change{Delete: []string{rec.Name}}
+	case rec.Op != "":
+		return rec, n, fmt.Errorf("invalid record %s", payload)
+	}
+	return rec, n, nil
+}
+
+// append makes c durable in the log, then applies it. The caller holds
+// the write lock.
+func (s *FileStore) append(c change) error {
+	payload, err := json.Marshal(walRecord{change: c})
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, headerSize, headerSize+len(payload))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	buf = append(buf, payload...)
+	end, err := s.wal.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = s.wal.Write(buf)
+	if err == nil {
+		err = s.wal.Sync()
+	}
+	if err != nil {
+		// Cut off whatever part of the record was written so that the
+		// next one does not follow garbage.
+		s.wal.Truncate(end)
+		s.wal.Seek(end, io.SeekStart)
+		return err
+	}
+	s.state.apply(c)
+	s.appended++
+	if s.appended >= s.snapshotEvery {
+		if err := s.snapshot(); err != nil {
+			// The change is already durable in the log; try again later.
+			log.Println("writing snapshot:", err)
+		}
+	}
+	return nil
+}
+
+// snapshot writes every item to a new snapshot file, atomically replaces
+// the old one, and empties the log. A crash before the log is emptied only
+// replays changes the snapshot already holds, which is harmless.
+func (s *FileStore) snapshot() error {
+	snap := snapshot{Version: 1, Items: []Item{}, Reservations: []Reservation{}}
+	for _, item := range s.state.items {
+		snap.Items = append(snap.Items, item)
+	}
+	for _, r := range s.state.reservations {
+		snap.Reservations = append(snap.Reservations, r)
+	}
+	for _, events := range s.state.history {
+		snap.Events = append(snap.Events, events...)
+	}
+	slices.SortFunc(snap.Events, func(a, b Event) int { return cmp.Compare(a.Seq, b.Seq) })
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.appended = 0
+	return s.wal.Sync()
+}
+
+// syncDir flushes a directory so that a rename within it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s *FileStore) Get(ctx context.Context, name string) (Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.get(name)
+}
+
+func (s *FileStore) List(ctx context.Context) ([]Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.list(), nil
+}
+
+func (s *FileStore) Watch(ctx context.Context, fn func(Event)) error {
+	s.mu.Lock()
+	s.state.watchers[&fn] = true
+	s.mu.Unlock()
+	<-ctx.Done()
+	s.mu.Lock()
+	delete(s.state.watchers, &fn)
+	s.mu.Unlock()
+	return ctx.Err()
+}
+
+func (s *FileStore) History(ctx context.Context, name string) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.events(name)
+}
+
+// apply logs and makes the change worked out by op, holding the write lock
+// throughout and recording the principal in ctx as its author.
+func (s *FileStore) apply(ctx context.Context, op func() (change, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := op()
+	if err != nil {
+		return err
+	}
+	s.state.stamp(ctx, c.Events)
+	return s.append(c)
+}
+
+func (s *FileStore) Query(ctx context.Context, q Query) ([]Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.query(q), nil
+}
+
+func (s *FileStore) Search(ctx context.Context, q SearchQuery) (SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.textSearch(q), nil
+}
+
+func (s *FileStore) Create(ctx context.Context, item Item) error {
+	return s.apply(ctx, func() (change, error) { return s.state.create(item) })
+}
+
+func (s *FileStore) Update(ctx context.Context, item Item) error {
+	return s.apply(ctx, func() (change, error) { return s.state.update(item) })
+}
+
+func (s *FileStore) Delete(ctx context.Context, name string, version int64) error {
+	return s.apply(ctx, func() (change, error) { return s.state.delete(name, version) })
+}
+
+func (s *FileStore) Reserve(ctx context.Context, lines []Line, expires time.Time) (r Reservation, err error) {
+	err = s.apply(ctx, func() (c change, err error) {
+		r, c, err = s.state.reserve(lines, expires)
+		return c, err
+	})
+	return r, err
+}
+
+func (s *FileStore) Reservation(ctx context.Context, id string) (Reservation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.reservation(id)
+}
+
+func (s *FileStore) Release(ctx context.Context, id string) error {
+	return s.apply(ctx, func() (change, error) { return s.state.release(id) })
+}
+
+func (s *FileStore) PlaceOrder(ctx context.Context, lines []Line, id string) (o Order, err error) {
+	err = s.apply(ctx, func() (c change, err error) {
+		o, c, err = s.state.order(lines, id)
+		return c, err
+	})
+	return o, err
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.wal.Close()
+}
@@ -0,0 +1,397 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	maxBodySize = 1 << 20 // Largest request body accepted by the JSON API.
+	retryAfter  = "5"     // Seconds a client is asked to wait when the store is unavailable.
+)
+
+// A Server serves an inventory over HTTP.
+type Server struct {
+	Store   InventoryStore
+	Legacy  bool           // Also serve the old query-string routes (/list, /price, /create, /update, /delete).
+	Auth    *Authenticator // Checks credentials and roles; the API is open to everyone if nil.
+	Audit   io.Writer      // Receives a JSON line for every attempt to change the inventory, if not nil.
+	Events  *Broker        // Streams changes to the clients of /events, which is only served if not nil.
+	Logger  *slog.Logger   // Receives an access log line for every request, if not nil.
+	Metrics *Metrics       // Counts requests, and is served at /metrics, if not nil.
+	// RequestTimeout bounds the time every request but the streams of
+	// /events, /items:import and /items:export has to use the store; there
+	// is no limit if it is zero.
+	RequestTimeout time.Duration
+}
+
+// Handler returns the HTTP handler for the JSON API, plus the legacy
+// routes if enabled, behind authentication and the audit log, and
+// request IDs, panic recovery and access logs.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/items", s.collection)
+	mux.HandleFunc("/items/", s.item)
+	mux.HandleFunc("/items:import", s.importItems)
+	mux.HandleFunc("/items:export", s.exportItems)
+	mux.HandleFunc("/search", s.search)
+	mux.HandleFunc("/reservations", s.reservations)
+	mux.HandleFunc("/reservations/", s.reservation)
+	mux.HandleFunc("/orders", s.orders)
+	if s.Events != nil {
+		mux.HandleFunc("/events", s.events)
+	}
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	if s.Metrics != nil {
+		mux.Handle("/metrics", s.Metrics)
+	}
+	if s.Legacy {
+		// The old routes accept any method, so GET /delete?item=shoes deletes data.
+		mux.HandleFunc("/list", s.list)
+		mux.HandleFunc("/price", s.price)
+		mux.HandleFunc("/create", s.create)
+		mux.HandleFunc("/update", s.update)
+		mux.HandleFunc("/delete", s.delete)
+	}
+	return s.observe(s.authorize(mux))
+}
+
+// An itemRequest is the body of a POST, PUT or PATCH. Fields left out are
+// nil so that PATCH can tell them apart from zero values.
+type itemRequest struct {
+	Name        *string   `json:"name"`
+	Price       *Money    `json:"price"`
+	Quantity    *int64    `json:"quantity"`
+	Description *string   `json:"description"`
+	Category    *string   `json:"category"`
+	Tags        *[]string `json:"tags"`
+}
+
+// Limits of the descriptive fields of an item.
+const (
+	maxDescriptionSize = 4096
+	maxCategorySize    = 64
+	maxTags            = 20
+	maxTagSize         = 32
+)
+
+// validate checks the fields given in the request.
+func (body itemRequest) validate() error {
+	if body.Quantity != nil && *body.Quantity < 0 {
+		return fmt.Errorf("quantity must not be negative, got %d", *body.Quantity)
+	}
+	if body.Description != nil && len(*body.Description) > maxDescriptionSize {
+		return fmt.Errorf("description is %d bytes; the limit is %d", len(*body.Description), maxDescriptionSize)
+	}
+	if body.Category != nil && len(strings.TrimSpace(*body.Category)) > maxCategorySize {
+		return fmt.Errorf("category is longer than %d bytes", maxCategorySize)
+	}
+	if body.Tags != nil {
+		if _, err := normalizeTags(*body.Tags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply copies the fields given in the request to item.
+func (body itemRequest) apply(item *Item) {
+	if body.Price != nil {
+		item.Price = *body.Price
+	}
+	if body.Quantity != nil {
+		item.Quantity = *body.Quantity
+	}
+	if body.Description != nil {
+		item.Description = strings.TrimSpace(*body.Description)
+	}
+	if body.Category != nil {
+		item.Category = strings.TrimSpace(*body.Category)
+	}
+	if body.Tags != nil {
+		item.Tags, _ = normalizeTags(*body.Tags)
+	}
+}
+
+// normalizeTags returns tags in lower case, sorted and without duplicates,
+// or an error if one is empty, too long or has characters other than
+// letters, digits and hyphens.
+func normalizeTags(tags []string) ([]string, error) {
+	var norm []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		switch {
+		case tag == "":
+			return nil, errors.New("tags must not be empty")
+		case len(tag) > maxTagSize:
+			return nil, fmt.Errorf("tag %q is longer than %d bytes", tag, maxTagSize)
+		case strings.ContainsFunc(tag, func(r rune) bool { return r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) }):
+			return nil, fmt.Errorf("tag %q may only have letters, digits and hyphens", tag)
+		}
+		norm = append(norm, tag)
+	}
+	slices.Sort(norm)
+	norm = slices.Compact(norm)
+	if len(norm) > maxTags {
+		return nil, fmt.Errorf("%d tags; the limit is %d", len(norm), maxTags)
+	}
+	return norm, nil
+}
+
+// An apiError is the envelope every JSON error response is wrapped in.
+type apiError struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// collection handles "/items": GET lists a page of items and POST creates
+// one. A page that is not the last comes with a Link header to the next.
+func (s *Server) collection(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		values := req.URL.Query()
+		q, err := parseQuery(values)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		limit := q.Limit
+		q.Limit++ // To see whether there is a next page.
+		items, err := s.Store.Query(req.Context(), q)
+		if err != nil {
+			s.storeError(w, req, err)
+			return
+		}
+		if len(items) > limit {
+			items = items[:limit]
+			q.Limit = limit
+			next := encodeCursor(q, items[limit-1])
+			values.Set("cursor", next)
+			w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", req.URL.Path, values.Encode()))
+			w.Header().Set("X-Next-Cursor", next)
+		}
+		if items == nil {
+			items = []Item{}
+		}
+		writeJSON(w, http.StatusOK, items)
+
+	case http.MethodPost:
+		var body itemRequest
+		if err := readJSON(w, req, &body); err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		if body.Name == nil || !validName(*body.Name) {
+			writeError(w, http.StatusBadRequest, "missing or invalid name")
+			return
+		}
+		if body.Price == nil {
+			writeError(w, http.StatusBadRequest, "missing price")
+			return
+		}
+		if err := body.validate(); err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		item := Item{Name: *body.Name}
+		body.apply(&item)
+		if err := s.Store.Create(req.Context(), item); err != nil {
+			s.storeError(w, req, err)
+			return
+		}
+		item.Version = 1
+		w.Header().Set("Location", "/items/"+url.PathEscape(item.Name))
+		w.Header().Set("ETag", etag(item.Version))
+		writeJSON(w, http.StatusCreated, item)
+
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPost)
+	}
+}
+
+// item handles "/items/{name}": GET shows an item, PUT replaces or creates
+// it, PATCH changes the fields given, and DELETE removes it. Responses
+// carry the item's ETag; GET honours If-None-Match, the others If-Match,
+// and PUT also If-None-Match: * to only create. Its history and restore
+// actions are under "/items/{name}/".
+func (s *Server) item(w http.ResponseWriter, req *http.Request) {
+	name, action := itemAction(req.URL.Path)
+	if !validName(name) {
+		writeError(w, http.StatusNotFound, "no such item: %q", name)
+		return
+	}
+	switch action {
+	case "history":
+		s.history(w, req, name)
+		return
+	case "restore":
+		s.restore(w, req, name)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		ctx := req.Context()
+		header := req.Header.Get("If-None-Match")
+		if header != "" {
+			// Not modified must not be answered from a stale cache.
+			ctx = fresh(ctx)
+		}
+		item, err := s.Store.Get(ctx, name)
+		if err != nil {
+			s.storeError(w, req, err)
+			return
+		}
+		w.Header().Set("ETag", etag(item.Version))
+		if header != "" && matchETag(header, item.Version, true) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeJSON(w, http.StatusOK, item)
+
+	case http.MethodPut, http.MethodPatch:
+		var body itemRequest
+		if err := readJSON(w, req, &body); err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		if body.Name != nil && *body.Name != name {
+			writeError(w, http.StatusBadRequest, "name %q does not match the URL", *body.Name)
+			return
+		}
+		if body.Price == nil && req.Method == http.MethodPut {
+			writeError(w, http.StatusBadRequest, "missing price")
+			return
+		}
+		if err := body.validate(); err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		if req.Method == http.MethodPut {
+			item, created, err := s.upsert(req, name, body.apply)
+			if err != nil {
+				s.storeError(w, req, err)
+				return
+			}
+			status := http.StatusOK
+			if created {
+				status = http.StatusCreated
+				w.Header().Set("Location", "/items/"+url.PathEscape(item.Name))
+			}
+			w.Header().Set("ETag", etag(item.Version))
+			writeJSON(w, status, item)
+			return
+		}
+		item, err := s.modify(req, name, body.apply)
+		if err != nil {
+			s.storeError(w, req, err)
+			return
+		}
+		w.Header().Set("ETag", etag(item.Version))
+		writeJSON(w, http.StatusOK, item)
+
+	case http.MethodDelete:
+		if err := s.remove(req, name); err != nil {
+			s.storeError(w, req, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodDelete)
+	}
+}
+
+// validName reports whether name can be used in an item's URL.
+func validName(name string) bool {
+	return name != "" && !strings.Contains(name, "/")
+}
+
+// readJSON decodes a single JSON value from the request body into v,
+// rejecting unknown fields and oversized bodies.
+func readJSON(w http.ResponseWriter, req *http.Request, v any) error {
+	if ct := req.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/json") {
+		return fmt.Errorf("unsupported content type %q", ct)
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("empty request body")
+		}
+		return fmt.Errorf("invalid JSON body: %v", err)
+	}
+	if dec.More() {
+		return errors.New("invalid JSON body: more than one value")
+	}
+	return nil
+}
+
+// writeJSON sends v as the JSON body of a response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("encoding response:", err)
+	}
+}
+
+// writeError sends an error response wrapped in the JSON error envelope.
+func writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	var e apiError
+	e.Error.Status = status
+	e.Error.Message = fmt.Sprintf(format, args...)
+	writeJSON(w, status, e)
+}
+
+// storeError sends the response for an error returned by the store. An
+// unexpected error is logged with the request's ID rather than shown to
+// the client, as it may reveal how the store works.
+func (s *Server) storeError(w http.ResponseWriter, req *http.Request, err error) {
+	status := statusOf(err)
+	switch status {
+	case http.StatusServiceUnavailable:
+		w.Header().Set("Retry-After", retryAfter)
+	case http.StatusInternalServerError:
+		s.logger().Error("store error", "request_id", RequestID(req.Context()), "method", req.Method, "uri", req.URL.RequestURI(), "error", err)
+		writeError(w, status, "internal error")
+		return
+	}
+	writeError(w, status, "%v", err)
+}
+
+// statusOf maps a store error to an HTTP status.
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrNoReservation):
+		return http.StatusNotFound
+	case errors.Is(err, ErrConflict), errors.Is(err, ErrInsufficientStock):
+		return http.StatusConflict
+	case errors.Is(err, ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, ErrUnavailable), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// methodNotAllowed answers a request whose method the route does not support.
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed; use %s", strings.Join(allowed, ", "))
+}
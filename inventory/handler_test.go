@@ -0,0 +1,285 @@
+package inventory
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T, legacy bool) *httptest.Server {
+	t.Helper()
+	s := &Server{Store: NewMemoryStore(DefaultItems...), Legacy: legacy}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func do(t *testing.T, ts *httptest.Server, method, path, body string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func TestItemsAPI(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, false)
+	tests := []struct {
+		method, path, body string
+		status             int
+		want               string
+	}{
+		{"GET", "/items", "", 200, `[{"name":"shoes","price":{"amount":"50.00","currency":"USD"},"quantity":10,"reserved":0,"version":1},{"name":"socks","price":{"amount":"5.00","currency":"USD"},"quantity":100,"reserved":0,"version":1}]`},
+		{"GET", "/items/shoes", "", 200, `{"name":"shoes","price":{"amount":"50.00","currency":"USD"},"quantity":10,"reserved":0,"version":1}`},
+		{"GET", "/items/hats", "", 404, `{"error":{"status":404,"message":"no such item: \"hats\""}}`},
+		{"POST", "/items", `{"name":"hats","price":{"amount":"19.99","currency":"USD"}}`, 201, `{"name":"hats","price":{"amount":"19.99","currency":"USD"},"quantity":0,"reserved":0,"version":1}`},
+		{"POST", "/items", `{"name":"hats","price":25}`, 409, `{"error":{"status":409,"message":"item already exists: \"hats\""}}`},
+		{"POST", "/items", `{"name":"belts"}`, 400, `{"error":{"status":400,"message":"missing price"}}`},
+		{"POST", "/items", `{"name":"belts","price":"cheap"}`, 400, ""},
+		{"POST", "/items", `{"name":"belts","price":1,"colour":"red"}`, 400, ""},
+		{"POST", "/items", `{"name":"belts","price":-1}`, 400, `{"error":{"status":400,"message":"invalid JSON body: amount must not be negative"}}`},
+		{"POST", "/items", `{"name":"belts","price":{"amount":"1","currency":"XXX"}}`, 400, ""},
+		{"POST", "/items", `{"name":"belts","price":"NaN"}`, 400, ""},
+		{"PUT", "/items/hats", `{"price":30,"quantity":3}`, 200, `{"name":"hats","price":{"amount":"30.00","currency":"USD"},"quantity":3,"reserved":0,"version":2}`},
+		{"PUT", "/items/hats", `{}`, 400, `{"error":{"status":400,"message":"missing price"}}`},
+		{"PUT", "/items/gloves", `{"price":30}`, 201, `{"name":"gloves","price":{"amount":"30.00","currency":"USD"},"quantity":0,"reserved":0,"version":1}`},
+		{"PUT", "/items/gloves", `{"price":30}`, 200, `{"name":"gloves","price":{"amount":"30.00","currency":"USD"},"quantity":0,"reserved":0,"version":2}`},
+		{"PATCH", "/items/hats", `{"price":"0.105"}`, 200, `{"name":"hats","price":{"amount":"0.10","currency":"USD"},"quantity":3,"reserved":0,"version":3}`},
+		{"PATCH", "/items/hats", `{"name":"caps"}`, 400, ""},
+		{"PATCH", "/items/hats", `{"quantity":-1}`, 400, `{"error":{"status":400,"message":"quantity must not be negative, got -1"}}`},
+		{"DELETE", "/items/hats", "", 204, ""},
+		{"DELETE", "/items/hats", "", 404, ""},
+		{"DELETE", "/items", "", 405, `{"error":{"status":405,"message":"method not allowed; use GET, HEAD, POST"}}`},
+		{"POST", "/items/shoes", "", 405, ""},
+	}
+	for _, tt := range tests {
+		resp, body := do(t, ts, tt.method, tt.path, tt.body)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: got status %d, want %d (%s)", tt.method, tt.path, resp.StatusCode, tt.status, body)
+			continue
+		}
+		if tt.want != "" && strings.TrimSpace(body) != tt.want {
+			t.Errorf("%s %s: got %s, want %s", tt.method, tt.path, body, tt.want)
+		}
+		if resp.StatusCode >= 400 {
+			var e apiError
+			if err := json.Unmarshal([]byte(body), &e); err != nil || e.Error.Status != tt.status || e.Error.Message == "" {
+				t.Errorf("%s %s: error body %q is not an error envelope", tt.method, tt.path, body)
+			}
+		}
+		if resp.StatusCode == 405 && resp.Header.Get("Allow") == "" {
+			t.Errorf("%s %s: 405 without an Allow header", tt.method, tt.path)
+		}
+	}
+}
+
+func TestLegacyRoutes(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, true)
+	tests := []struct {
+		path   string
+		status int
+		want   string
+	}{
+		{"/list", 200, "shoes: $50.00\nsocks: $5.00\n"},
+		{"/price?item=socks", 200, "$5.00\n"},
+		{"/price?item=hats", 404, "no such item: \"hats\"\n"},
+		{"/create?item=hats&price=19.99", 200, "created hats: $19.99\n"},
+		{"/create?item=hats&price=19.99", 409, "item already exists: \"hats\"\n"},
+		{"/create?item=belts&price=NaN", 400, "invalid price: \"NaN\"\n"},
+		{"/update?item=hats&price=20", 200, "updated hats: $20.00\n"},
+		{"/update?item=belts&price=20", 404, "no such item: \"belts\"\n"},
+		{"/delete?item=hats", 200, "deleted hats\n"},
+		{"/delete?item=hats", 404, "no such item: \"hats\"\n"},
+	}
+	for _, tt := range tests {
+		resp, body := do(t, ts, "GET", tt.path, "")
+		if resp.StatusCode != tt.status || body != tt.want {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, resp.StatusCode, body, tt.status, tt.want)
+		}
+	}
+
+	if resp, _ := do(t, newTestServer(t, false), "GET", "/delete?item=shoes", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("legacy route served with Legacy unset: status %d", resp.StatusCode)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, false)
+	tests := []struct {
+		method, path, header, body string
+		status                     int
+		etag                       string
+	}{
+		{"GET", "/items/shoes", "", "", 200, `"1"`},
+		{"GET", "/items/shoes", `If-None-Match: "1"`, "", 304, `"1"`},
+		{"GET", "/items/shoes", `If-None-Match: "7", W/"1"`, "", 304, `"1"`},
+		{"GET", "/items/shoes", `If-None-Match: *`, "", 304, `"1"`},
+		{"GET", "/items/shoes", `If-None-Match: "2"`, "", 200, `"1"`},
+		{"PATCH", "/items/shoes", `If-Match: "2"`, `{"quantity":5}`, 412, ""},
+		{"PATCH", "/items/shoes", `If-Match: W/"1"`, `{"quantity":5}`, 412, ""},
+		{"PATCH", "/items/shoes", `If-Match: "1"`, `{"quantity":5}`, 200, `"2"`},
+		{"PUT", "/items/shoes", `If-Match: "1"`, `{"price":1}`, 412, ""},
+		{"PUT", "/items/shoes", `If-Match: *`, `{"price":1}`, 200, `"3"`},
+		{"PUT", "/items/hats", `If-Match: *`, `{"price":1}`, 412, ""},
+		{"PUT", "/items/shoes", `If-None-Match: *`, `{"price":1}`, 412, ""},
+		{"PUT", "/items/hats", `If-None-Match: *`, `{"price":1}`, 201, `"1"`},
+		{"PATCH", "/items/shoes", "", `{"price":2}`, 200, `"4"`},
+		{"DELETE", "/items/shoes", `If-Match: "3"`, "", 412, ""},
+		{"GET", "/items/shoes", "", "", 200, `"4"`},
+		{"DELETE", "/items/shoes", `If-Match: "2", "4"`, "", 204, ""},
+		{"DELETE", "/items/shoes", `If-Match: "4"`, "", 412, ""},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name, value, ok := strings.Cut(tt.header, ": "); ok {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || resp.Header.Get("ETag") != tt.etag {
+			t.Errorf("%s %s with %s: got %d, ETag %s; want %d, ETag %s",
+				tt.method, tt.path, tt.header, resp.StatusCode, resp.Header.Get("ETag"), tt.status, tt.etag)
+		}
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, true)
+	// Only one of the clients that read version 1 can change it, while
+	// changes without If-Match are retried until they all land. Each client
+	// makes one change, so none can lose more than maxRetries times.
+	const clients = maxRetries
+	statuses := make(chan int, clients)
+	for i := 0; i < clients; i++ {
+		go func() {
+			req, _ := http.NewRequest("PATCH", ts.URL+"/items/socks", strings.NewReader(`{"price":1}`))
+			req.Header.Set("If-Match", `"1"`)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	won := 0
+	for i := 0; i < clients; i++ {
+		switch status := <-statuses; status {
+		case 200:
+			won++
+		case 412:
+		default:
+			t.Errorf("conditional PATCH: status %d", status)
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d conditional updates of the same version succeeded, want 1", won)
+	}
+
+	done := make(chan bool)
+	for i := 0; i < clients; i++ {
+		go func() {
+			resp, err := http.Get(ts.URL + "/update?item=shoes&price=1")
+			if err != nil {
+				done <- false
+				return
+			}
+			resp.Body.Close()
+			done <- resp.StatusCode == 200
+		}()
+	}
+	for i := 0; i < clients; i++ {
+		if !<-done {
+			t.Error("unconditional legacy update failed")
+		}
+	}
+	if resp, _ := do(t, ts, "GET", "/items/shoes", ""); resp.Header.Get("ETag") != etag(1+clients) {
+		t.Errorf("after %d updates the ETag is %s", clients, resp.Header.Get("ETag"))
+	}
+}
+
+func TestItemsPagination(t *testing.T) {
+	t.Parallel()
+	s := &Server{Store: NewMemoryStore(
+		item("belts", 1500), item("hats", 2000), item("scarves", 1500), item("shirts", 2500), item("shoes", 5000), item("socks", 500),
+	)}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+
+	var names []string
+	path := "/items?sort=price&order=desc&max_price=25&limit=2"
+	for pages := 0; path != ""; pages++ {
+		if pages > 3 {
+			t.Fatal("too many pages")
+		}
+		resp, body := do(t, ts, "GET", path, "")
+		var items []Item
+		if resp.StatusCode != 200 || json.Unmarshal([]byte(body), &items) != nil {
+			t.Fatalf("GET %s = %d %s", path, resp.StatusCode, body)
+		}
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+		path = ""
+		if link := resp.Header.Get("Link"); link != "" {
+			path = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+			if !strings.Contains(path, "cursor="+resp.Header.Get("X-Next-Cursor")) {
+				t.Errorf("Link %s does not hold the next cursor", link)
+			}
+		}
+	}
+	if want := "shirts hats scarves belts socks"; strings.Join(names, " ") != want {
+		t.Errorf("pages held %v, want %s", names, want)
+	}
+
+	resp, body := do(t, ts, "GET", "/items?q=sh&limit=1", "")
+	if resp.StatusCode != 200 || !strings.HasPrefix(body, `[{"name":"shirts"`) || resp.Header.Get("Link") == "" {
+		t.Errorf("GET /items?q=sh&limit=1 = %d %s", resp.StatusCode, body)
+	}
+	cursor := resp.Header.Get("X-Next-Cursor")
+	if resp, body := do(t, ts, "GET", "/items?q=sh&cursor="+cursor, ""); !strings.HasPrefix(body, `[{"name":"shoes"`) || resp.Header.Get("Link") != "" {
+		t.Errorf("second page = %s, Link %q", body, resp.Header.Get("Link"))
+	}
+	if _, body := do(t, ts, "GET", "/items?q=x", ""); body != "[]\n" {
+		t.Errorf("empty page = %q, want []", body)
+	}
+
+	for _, path := range []string{
+		"/items?limit=0",
+		"/items?limit=1001",
+		"/items?sort=colour",
+		"/items?order=up",
+		"/items?min_price=cheap",
+		"/items?min_price=10&max_price=5",
+		"/items?cursor=nonsense",
+		"/items?sort=price&cursor=" + cursor,
+	} {
+		if resp, _ := do(t, ts, "GET", path, ""); resp.StatusCode != 400 {
+			t.Errorf("GET %s: status %d, want 400", path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const readyTimeout = 2 * time.Second // For the store to answer a readiness check.
+
+// A Pinger is a store that depends on a server, and can check that it is
+// reachable. Stores that are not Pingers are always ready.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ping checks that store is reachable, if it is a Pinger.
+func ping(ctx context.Context, store InventoryStore) error {
+	if p, ok := store.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+// healthz handles "/healthz": GET answers as long as the server is
+// running, for liveness probes.
+func (s *Server) healthz(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	writeJSON(w, http.StatusOK, healthResponse{"ok"})
+}
+
+// readyz handles "/readyz": GET answers 503 Service Unavailable if the
+// store cannot be reached, for readiness probes.
+func (s *Server) readyz(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), readyTimeout)
+	defer cancel()
+	if err := ping(ctx, s.Store); err != nil {
+		writeError(w, http.StatusServiceUnavailable, "store unavailable: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, healthResponse{"ok"})
+}
+
+// CheckHealth gets /readyz from the server listening on addr and reports
+// an error unless it answers 200 OK. It lets a server's binary be its own
+// health check in a container with nothing else in it.
+func CheckHealth(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	url := "http://" + net.JoinHostPort(host, port) + "/readyz"
+	client := &http.Client{Timeout: 2 * readyTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// downStore is a store whose server cannot be reached.
+type downStore struct{ InventoryStore }
+
+var errDown = errors.New("connection refused")
+
+func (downStore) Ping(ctx context.Context) error { return errDown }
+
+func (downStore) Get(ctx context.Context, name string) (Item, error) { return Item{}, errDown }
+
+func TestHealth(t *testing.T) {
+	t.Parallel()
+	auth, err := NewAuthenticator("alice:admin:sesame", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := httptest.NewServer((&Server{Store: NewMemoryStore(), Auth: auth}).Handler())
+	t.Cleanup(up.Close)
+	down := httptest.NewServer((&Server{Store: downStore{NewMemoryStore()}, Auth: auth}).Handler())
+	t.Cleanup(down.Close)
+
+	// Probes need no credentials.
+	tests := []struct {
+		ts           *httptest.Server
+		method, path string
+		status       int
+	}{
+		{up, "GET", "/healthz", http.StatusOK},
+		{up, "GET", "/readyz", http.StatusOK},
+		{up, "POST", "/readyz", http.StatusMethodNotAllowed},
+		{up, "GET", "/metrics", http.StatusNotFound},
+		{up, "GET", "/items", http.StatusUnauthorized},
+		{down, "GET", "/healthz", http.StatusOK},
+		{down, "HEAD", "/readyz", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		if resp, _ := do(t, tt.ts, tt.method, tt.path, ""); resp.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+		}
+	}
+
+	if err := CheckHealth(up.Listener.Addr().String()); err != nil {
+		t.Errorf("CheckHealth of a ready server: %v", err)
+	}
+	if err := CheckHealth(down.Listener.Addr().String()); err == nil {
+		t.Error("CheckHealth of a server whose store is down succeeded")
+	}
+}
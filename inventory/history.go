@@ -0,0 +1,136 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Kinds of change recorded in an item's history.
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+	OpOrder  = "order" // Stock taken by an order.
+)
+
+// An Event records one change to an item. Stores keep every event, even
+// after the item is deleted, and never change one once recorded.
+type Event struct {
+	Seq  int64     `json:"seq,omitempty"` // Position in the store's history, for stores that number events.
+	Item string    `json:"item"`
+	Op   string    `json:"op"`
+	Time time.Time `json:"time"`
+	User string    `json:"user,omitempty"` // Who made the change; empty when authentication is off.
+	Old  *Item     `json:"old,omitempty"`  // The item before the change; nil for OpCreate.
+	New  *Item     `json:"new,omitempty"`  // The item after the change; nil for OpDelete.
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a context carrying p, for stores to record
+// who made a change.
+func ContextWithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal carried by ctx, if any.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// stamp numbers events after the last one s applied and sets their time
+// and user.
+func (s *state) stamp(ctx context.Context, events []Event) {
+	p, _ := PrincipalFromContext(ctx)
+	now := s.now().UTC()
+	for i := range events {
+		events[i].Seq = s.seq + int64(i) + 1
+		events[i].Time = now
+		events[i].User = p.Name
+	}
+}
+
+// A restoreRequest is the body of a POST to /items/{name}/restore.
+type restoreRequest struct {
+	Version int64 `json:"version"`
+}
+
+// history handles "/items/{name}/history": GET lists the item's changes,
+// oldest first.
+func (s *Server) history(w http.ResponseWriter, req *http.Request, name string) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	events, err := s.Store.History(req.Context(), name)
+	if err != nil {
+		s.storeError(w, req, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// restore handles "/items/{name}/restore": POST sets the item's price,
+// quantity, description, category and tags back to those it had at a past
+// version, recreating it if it has been deleted. The restore is a change of
+// its own, with a new version, and is refused like any other if it would
+// leave less on hand than is reserved. If the item was deleted and created
+// again, the version refers to its latest life.
+func (s *Server) restore(w http.ResponseWriter, req *http.Request, name string) {
+	if req.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var body restoreRequest
+	if err := readJSON(w, req, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	events, err := s.Store.History(req.Context(), name)
+	if err != nil {
+		s.storeError(w, req, err)
+		return
+	}
+	var past *Item
+	for i := len(events) - 1; i >= 0 && past == nil; i-- {
+		if e := events[i]; e.New != nil && e.New.Version == body.Version {
+			past = e.New
+		}
+	}
+	if past == nil {
+		writeError(w, http.StatusNotFound, "%q has no version %d", name, body.Version)
+		return
+	}
+
+	item, err := s.modify(req, name, func(item *Item) { item.setFields(*past) })
+	if errors.Is(err, ErrNotFound) {
+		item = Item{Name: name, Version: 1}
+		item.setFields(*past)
+		if err = s.Store.Create(req.Context(), item); errors.Is(err, ErrConflict) {
+			err = fmt.Errorf("%w: %q was created again while it was being restored", ErrVersionMismatch, name)
+		}
+	}
+	if err != nil {
+		s.storeError(w, req, err)
+		return
+	}
+	w.Header().Set("ETag", etag(item.Version))
+	writeJSON(w, http.StatusOK, item)
+}
+
+// itemAction splits the path of a request under /items/ into the item's
+// name and the action after it, if any.
+func itemAction(path string) (name, action string) {
+	name = strings.TrimPrefix(path, "/items/")
+	for _, a := range []string{"history", "restore"} {
+		if n, ok := strings.CutSuffix(name, "/"+a); ok {
+			return n, a
+		}
+	}
+	return name, ""
+}
@@ -0,0 +1,210 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// summary describes events as "op user old-version>new-version" lines.
+func summary(events []Event) string {
+	var lines []string
+	for _, e := range events {
+		var old, new int64
+		if e.Old != nil {
+			old = e.Old.Version
+		}
+		if e.New != nil {
+			new = e.New.Version
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %d>%d", e.Op, e.User, old, new))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// testHistory checks the history a store records, starting from an empty
+// store.
+func testHistory(t *testing.T, s InventoryStore) {
+	t.Helper()
+	alice := ContextWithPrincipal(context.Background(), Principal{"alice", RoleAdmin})
+	bob := ContextWithPrincipal(context.Background(), Principal{"bob", RoleAdmin})
+
+	if _, err := s.History(alice, "hats"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("History of a new item: got %v, want ErrNotFound", err)
+	}
+	if err := s.Create(alice, Item{Name: "hats", Price: Money{1000, "USD"}, Quantity: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update(bob, Item{Name: "hats", Price: Money{1200, "USD"}, Quantity: 10}); err != nil {
+		t.Fatal(err)
+	}
+	// Changes that fail leave no trace.
+	if err := s.Update(bob, Item{Name: "hats", Price: Money{1, "USD"}, Version: 1}); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("stale update: got %v, want ErrVersionMismatch", err)
+	}
+	if _, err := s.PlaceOrder(context.Background(), []Line{{"hats", 3}}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(alice, "hats", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := s.History(context.Background(), "hats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "create alice 0>1\nupdate bob 1>2\norder  2>3\ndelete alice 3>0"
+	if got := summary(events); got != want {
+		t.Errorf("history:\n%s\nwant:\n%s", got, want)
+	}
+	if len(events) == 4 {
+		if e := events[1]; e.Old.Price.Amount != 1000 || e.New.Price.Amount != 1200 {
+			t.Errorf("update event: old %v, new %v", e.Old, e.New)
+		}
+		if e := events[2]; e.Old.Quantity != 10 || e.New.Quantity != 7 {
+			t.Errorf("order event: old %v, new %v", e.Old, e.New)
+		}
+	}
+	for i, e := range events {
+		if e.Item != "hats" || e.Time.IsZero() || i > 0 && e.Time.Before(events[i-1].Time) {
+			t.Errorf("event %d: %+v", i, e)
+		}
+	}
+}
+
+func TestMemoryStoreHistory(t *testing.T) {
+	t.Parallel()
+	testHistory(t, NewMemoryStore())
+}
+
+func TestFileStoreHistory(t *testing.T) {
+	t.Parallel()
+	s := newFileStore(t)
+	testHistory(t, s)
+	want, _ := s.History(context.Background(), "hats")
+
+	check := func(s *FileStore) {
+		t.Helper()
+		if got, err := s.History(context.Background(), "hats"); err != nil || summary(got) != summary(want) {
+			t.Errorf("history after reopening:\n%s\n%v\nwant:\n%s", summary(got), err, summary(want))
+		}
+	}
+	s = reopen(t, s)
+	check(s)
+
+	// A crash between writing a snapshot and emptying the log replays
+	// changes the snapshot holds, which must not be recorded twice.
+	log, err := os.ReadFile(filepath.Join(s.dir, walFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, walFile), log, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s = reopen(t, s)
+	check(s)
+}
+
+func TestHistoryAPI(t *testing.T) {
+	t.Parallel()
+	auth, err := NewAuthenticator("alice:admin:sesame, bob:reader:letmein", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Store: NewMemoryStore(DefaultItems...), Auth: auth}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	as := func(key, method, path, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	history := func() []Event {
+		t.Helper()
+		req, _ := http.NewRequest("GET", ts.URL+"/items/shoes/history", nil)
+		req.Header.Set("X-API-Key", "letmein")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var events []Event
+		if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&events) != nil {
+			t.Fatalf("GET history: %s", resp.Status)
+		}
+		return events
+	}
+
+	as("sesame", "PATCH", "/items/shoes", `{"price":60}`)
+	as("sesame", "PATCH", "/items/shoes", `{"quantity":5}`)
+	if resp := as("letmein", "POST", "/items/shoes/restore", `{"version":1}`); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("restore by a reader: status %d, want 403", resp.StatusCode)
+	}
+	if resp := as("sesame", "POST", "/items/shoes/restore", `{"version":1}`); resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != `"4"` {
+		t.Errorf("restore: status %d, ETag %s; want 200, \"4\"", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+	if item, _ := s.Store.Get(context.Background(), "shoes"); item.Price != (Money{5000, "USD"}) || item.Quantity != 10 || item.Version != 4 {
+		t.Errorf("restored item is %+v", item)
+	}
+	want := "create  0>1\nupdate alice 1>2\nupdate alice 2>3\nupdate alice 3>4"
+	if got := summary(history()); got != want {
+		t.Errorf("history:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A deleted item can be restored, and comes back as a new item.
+	as("sesame", "DELETE", "/items/shoes", "")
+	if resp := as("sesame", "POST", "/items/shoes/restore", `{"version":2}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("restoring a deleted item: status %d, want 200", resp.StatusCode)
+	}
+	if item, _ := s.Store.Get(context.Background(), "shoes"); item.Price != (Money{6000, "USD"}) || item.Version != 1 {
+		t.Errorf("restored item is %+v", item)
+	}
+	if events := history(); len(events) != 6 || events[4].Op != OpDelete || events[5].Op != OpCreate {
+		t.Errorf("history after restoring a deleted item:\n%s", summary(events))
+	}
+
+	// Nor can a restore leave less on hand than is reserved.
+	as("sesame", "PATCH", "/items/shoes", `{"quantity":20}`)
+	if _, err := s.Store.Reserve(context.Background(), []Line{{"shoes", 15}}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if resp := as("sesame", "POST", "/items/shoes/restore", `{"version":1}`); resp.StatusCode != http.StatusConflict {
+		t.Errorf("restoring below the reserved quantity: status %d, want 409", resp.StatusCode)
+	}
+	if item, _ := s.Store.Get(context.Background(), "shoes"); item.Quantity != 20 || item.Reserved != 15 {
+		t.Errorf("after a refused restore, item is %+v", item)
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{"POST", "/items/shoes/restore", `{"version":9}`, http.StatusNotFound},
+		{"POST", "/items/shoes/restore", `{"version":"one"}`, http.StatusBadRequest},
+		{"GET", "/items/shoes/restore", "", http.StatusMethodNotAllowed},
+		{"POST", "/items/hats/restore", `{"version":1}`, http.StatusNotFound},
+		{"GET", "/items/hats/history", "", http.StatusNotFound},
+		{"DELETE", "/items/shoes/history", "", http.StatusMethodNotAllowed},
+	} {
+		if resp := as("sesame", tt.method, tt.path, tt.body); resp.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+		}
+	}
+}
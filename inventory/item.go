@@ -0,0 +1,132 @@
+// Package inventory is the inventory webserver shared by lab4, lab7 and
+// lab8: the items it sells, the stores that keep them, and the HTTP
+// handlers that serve them.
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// An Item is something in the inventory.
+type Item struct {
+	Name     string `json:"name"`
+	Price    Money  `json:"price"`
+	Quantity int64  `json:"quantity"` // Units on hand.
+	Reserved int64  `json:"reserved"` // Units on hand held by reservations; set by stores.
+
+	// What the item is, for people and for search.
+	Description string   `json:"description,omitempty"`
+	Category    string   `json:"category,omitempty"`
+	Tags        []string `json:"tags,omitempty"` // Lower case, sorted and distinct.
+
+	// Version counts the changes to the item, starting from 1 when it is
+	// created; stores set it. Reservations do not change it.
+	Version int64 `json:"version"`
+}
+
+// Available returns the units that can still be reserved or ordered.
+func (item Item) Available() int64 { return item.Quantity - item.Reserved }
+
+// setFields copies the fields clients set, all but the name, from other.
+func (item *Item) setFields(other Item) {
+	item.Price, item.Quantity = other.Price, other.Quantity
+	item.Description, item.Category, item.Tags = other.Description, other.Category, other.Tags
+}
+
+// Errors returned by stores, wrapped with the name of the item concerned.
+var (
+	ErrNotFound        = errors.New("no such item")
+	ErrConflict        = errors.New("item already exists")
+	ErrVersionMismatch = errors.New("item has changed")
+	// ErrUnavailable is returned when the store cannot be reached, or does
+	// not answer in time; the request may succeed if tried again later.
+	ErrUnavailable = errors.New("store unavailable")
+)
+
+// notFound and conflict return the errors a store reports for name.
+func notFound(name string) error { return fmt.Errorf("%w: %q", ErrNotFound, name) }
+func conflict(name string) error { return fmt.Errorf("%w: %q", ErrConflict, name) }
+
+// mismatch returns the error a store reports when name is not at the
+// version a change expected.
+func mismatch(name string, want, have int64) error {
+	return fmt.Errorf("%w: %q is at version %d, not %d", ErrVersionMismatch, name, have, want)
+}
+
+// An InventoryStore keeps items. Implementations are safe for concurrent use.
+type InventoryStore interface {
+	// Get returns the named item, or an error wrapping ErrNotFound.
+	Get(ctx context.Context, name string) (Item, error)
+	// List returns every item, sorted by name.
+	List(ctx context.Context) ([]Item, error)
+	// Query returns up to q.Limit of the items selected by q, in its order.
+	Query(ctx context.Context, q Query) ([]Item, error)
+	// Search returns a page of the items with any of the words in q.Text,
+	// best matches first, and counts of all the matches by facet.
+	Search(ctx context.Context, q SearchQuery) (SearchResult, error)
+	// Create adds a new item, or returns an error wrapping ErrConflict if
+	// one with the same name exists.
+	Create(ctx context.Context, item Item) error
+	// Update replaces an existing item, or returns an error wrapping
+	// ErrNotFound, and increments its version. If item.Version is not zero
+	// the stored item must be at that version, or Update returns an error
+	// wrapping ErrVersionMismatch. It returns an error wrapping
+	// ErrInsufficientStock rather than leave less on hand than is reserved.
+	Update(ctx context.Context, item Item) error
+	// Delete removes the named item, or returns an error wrapping
+	// ErrNotFound. If version is not zero the item must be at that version,
+	// as for Update.
+	Delete(ctx context.Context, name string, version int64) error
+
+	// Reserve holds the quantities in lines, which must have been merged by
+	// MergeLines, until expires. If any item is short it reserves nothing
+	// and returns an error wrapping ErrInsufficientStock.
+	Reserve(ctx context.Context, lines []Line, expires time.Time) (Reservation, error)
+	// Reservation returns an unexpired reservation, or an error wrapping
+	// ErrNoReservation.
+	Reservation(ctx context.Context, id string) (Reservation, error)
+	// Release cancels an unexpired reservation, or returns an error
+	// wrapping ErrNoReservation.
+	Release(ctx context.Context, id string) error
+	// PlaceOrder takes the lines of the unexpired reservation id out of
+	// stock or, if id is empty, the given lines, which must have been
+	// merged by MergeLines. If any item is short it takes nothing and
+	// returns an error wrapping ErrInsufficientStock.
+	PlaceOrder(ctx context.Context, lines []Line, id string) (Order, error)
+
+	// History returns the changes made to the named item, oldest first, or
+	// an error wrapping ErrNotFound if there are none. Each change records
+	// the principal in the context it was made with, if any.
+	History(ctx context.Context, name string) ([]Event, error)
+
+	// Watch calls fn with every change made to the store, in order, until
+	// ctx is done, when it returns ctx.Err(). fn must not block or use
+	// the store.
+	Watch(ctx context.Context, fn func(Event)) error
+}
+
+// DefaultItems are the items a new inventory starts with.
+var DefaultItems = []Item{
+	{Name: "shoes", Price: Money{5000, "USD"}, Quantity: 10},
+	{Name: "socks", Price: Money{500, "USD"}, Quantity: 100},
+}
+
+// Seed creates whichever of items store does not have yet.
+func Seed(ctx context.Context, store InventoryStore, items ...Item) error {
+	for _, item := range items {
+		_, err := store.Get(ctx, item.Name)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if err := store.Create(ctx, item); err != nil && !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package inventory
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// The handlers below serve the original query-string routes, kept for
+// clients written against them.
+
+// list handles "/list", listing every item.
+func (s *Server) list(w http.ResponseWriter, req *http.Request) {
+	items, err := s.Store.List(req.Context())
+	if err != nil {
+		legacyError(w, err)
+		return
+	}
+	for _, item := range items {
+		fmt.Fprintf(w, "%s: %s\n", item.Name, item.Price)
+	}
+}
+
+// price handles "/price?item=", showing the price of an item.
+func (s *Server) price(w http.ResponseWriter, req *http.Request) {
+	item, err := s.Store.Get(req.Context(), req.URL.Query().Get("item"))
+	if err != nil {
+		legacyError(w, err)
+		return
+	}
+	fmt.Fprintf(w, "%s\n", item.Price)
+}
+
+// create handles "/create?item=&price=", adding a new item.
+func (s *Server) create(w http.ResponseWriter, req *http.Request) {
+	item, ok := legacyItem(w, req)
+	if !ok {
+		return
+	}
+	if err := s.Store.Create(req.Context(), item); err != nil {
+		legacyError(w, err)
+		return
+	}
+	fmt.Fprintf(w, "created %s: %s\n", item.Name, item.Price)
+}
+
+// update handles "/update?item=&price=", changing the price of an item.
+func (s *Server) update(w http.ResponseWriter, req *http.Request) {
+	item, ok := legacyItem(w, req)
+	if !ok {
+		return
+	}
+	_, err := s.modify(req, item.Name, func(current *Item) { current.Price = item.Price })
+	if err != nil {
+		legacyError(w, err)
+		return
+	}
+	fmt.Fprintf(w, "updated %s: %s\n", item.Name, item.Price)
+}
+
+// delete handles "/delete?item=", removing an item.
+func (s *Server) delete(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("item")
+	if err := s.remove(req, name); err != nil {
+		legacyError(w, err)
+		return
+	}
+	fmt.Fprintf(w, "deleted %s\n", name)
+}
+
+// legacyItem reads the item and price query parameters.
+func legacyItem(w http.ResponseWriter, req *http.Request) (Item, bool) {
+	priceStr := req.URL.Query().Get("price")
+	price, err := ParseMoney(priceStr, DefaultCurrency)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "invalid price: %q\n", priceStr)
+		return Item{}, false
+	}
+	return Item{Name: req.URL.Query().Get("item"), Price: price}, true
+}
+
+// legacyError reports a store error as plain text.
+func legacyError(w http.ResponseWriter, err error) {
+	status := statusOf(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", retryAfter)
+	}
+	w.WriteHeader(status)
+	fmt.Fprintln(w, err)
+}
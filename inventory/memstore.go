@@ -0,0 +1,123 @@
+package inventory
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A MemoryStore keeps items in memory only, losing them on restart.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	state *state
+}
+
+// NewMemoryStore returns a store holding items, whose history starts with
+// their creation.
+func NewMemoryStore(items ...Item) *MemoryStore {
+	s := &MemoryStore{state: newState()}
+	for _, item := range items {
+		item.Reserved = 0
+		if item.Version == 0 {
+			item.Version = 1
+		}
+		created := item
+		c := change{Put: []Item{item}, Events: []Event{{Item: item.Name, Op: OpCreate, New: &created}}}
+		s.state.stamp(context.Background(), c.Events)
+		s.state.apply(c)
+	}
+	return s
+}
+
+func (s *MemoryStore) Get(ctx context.Context, name string) (Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.get(name)
+}
+
+func (s *MemoryStore) List(ctx context.Context) ([]Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.list(), nil
+}
+
+func (s *MemoryStore) Query(ctx context.Context, q Query) ([]Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.query(q), nil
+}
+
+func (s *MemoryStore) Search(ctx context.Context, q SearchQuery) (SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.textSearch(q), nil
+}
+
+func (s *MemoryStore) Watch(ctx context.Context, fn func(Event)) error {
+	s.mu.Lock()
+	s.state.watchers[&fn] = true
+	s.mu.Unlock()
+	<-ctx.Done()
+	s.mu.Lock()
+	delete(s.state.watchers, &fn)
+	s.mu.Unlock()
+	return ctx.Err()
+}
+
+func (s *MemoryStore) History(ctx context.Context, name string) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.events(name)
+}
+
+// apply makes the change worked out by op, holding the write lock for both,
+// recording the principal in ctx as its author.
+func (s *MemoryStore) apply(ctx context.Context, op func() (change, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := op()
+	if err != nil {
+		return err
+	}
+	s.state.stamp(ctx, c.Events)
+	s.state.apply(c)
+	return nil
+}
+
+func (s *MemoryStore) Create(ctx context.Context, item Item) error {
+	return s.apply(ctx, func() (change, error) { return s.state.create(item) })
+}
+
+func (s *MemoryStore) Update(ctx context.Context, item Item) error {
+	return s.apply(ctx, func() (change, error) { return s.state.update(item) })
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, name string, version int64) error {
+	return s.apply(ctx, func() (change, error) { return s.state.delete(name, version) })
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, lines []Line, expires time.Time) (r Reservation, err error) {
+	err = s.apply(ctx, func() (c change, err error) {
+		r, c, err = s.state.reserve(lines, expires)
+		return c, err
+	})
+	return r, err
+}
+
+func (s *MemoryStore) Reservation(ctx context.Context, id string) (Reservation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.reservation(id)
+}
+
+func (s *MemoryStore) Release(ctx context.Context, id string) error {
+	return s.apply(ctx, func() (change, error) { return s.state.release(id) })
+}
+
+func (s *MemoryStore) PlaceOrder(ctx context.Context, lines []Line, id string) (o Order, err error) {
+	err = s.apply(ctx, func() (c change, err error) {
+		o, c, err = s.state.order(lines, id)
+		return c, err
+	})
+	return o, err
+}
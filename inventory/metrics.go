@@ -0,0 +1,290 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency
+// histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	route, method string
+	status        int
+}
+
+// A histogram counts observations into latencyBuckets.
+type histogram struct {
+	counts []uint64 // Per bucket, plus one for those above the last.
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(latencyBuckets, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// Metrics counts the requests a server handles and the errors its store
+// returns, and serves them in the Prometheus text format.
+type Metrics struct {
+	mu          sync.Mutex
+	requests    map[requestKey]uint64
+	latency     map[string]*histogram // By route.
+	inFlight    int64
+	storeErrors map[string]uint64 // By store method.
+	cacheHits   uint64
+	cacheMisses uint64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:    map[requestKey]uint64{},
+		latency:     map[string]*histogram{},
+		storeErrors: map[string]uint64{},
+	}
+}
+
+// begin notes the start of a request, returning the function that notes
+// its end.
+func (m *Metrics) begin(route, method string) func(status int) {
+	start := time.Now()
+	m.mu.Lock()
+	m.inFlight++
+	m.mu.Unlock()
+	return func(status int) {
+		d := time.Since(start).Seconds()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.inFlight--
+		m.requests[requestKey{route, method, status}]++
+		h := m.latency[route]
+		if h == nil {
+			h = &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+			m.latency[route] = h
+		}
+		h.observe(d)
+	}
+}
+
+// storeError counts err, returned by the store method op, if it is a
+// failure of the store, such as ErrUnavailable, rather than an answer such
+// as ErrNotFound.
+func (m *Metrics) storeError(op string, err error) {
+	if err == nil || statusOf(err) < http.StatusInternalServerError || errors.Is(err, context.Canceled) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.storeErrors[op]++
+}
+
+// cacheLookup counts a lookup in a Cache. m may be nil.
+func (m *Metrics) cacheLookup(hit bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	var b strings.Builder
+	m.mu.Lock()
+	fmt.Fprintf(&b, "# HELP inventory_http_requests_total Requests handled, by route, method and status.\n")
+	fmt.Fprintf(&b, "# TYPE inventory_http_requests_total counter\n")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&b, "inventory_http_requests_total{route=%q,method=%q,status=\"%d\"} %d\n", k.route, k.method, k.status, m.requests[k])
+	}
+
+	fmt.Fprintf(&b, "# HELP inventory_http_request_duration_seconds Time taken to handle requests, by route.\n")
+	fmt.Fprintf(&b, "# TYPE inventory_http_request_duration_seconds histogram\n")
+	for _, route := range sortedKeys(m.latency) {
+		h := m.latency[route]
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "inventory_http_request_duration_seconds_bucket{route=%q,le=%q} %d\n", route, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&b, "inventory_http_request_duration_seconds_bucket{route=%q,le=\"+Inf\"} %d\n", route, h.count)
+		fmt.Fprintf(&b, "inventory_http_request_duration_seconds_sum{route=%q} %g\n", route, h.sum)
+		fmt.Fprintf(&b, "inventory_http_request_duration_seconds_count{route=%q} %d\n", route, h.count)
+	}
+
+	fmt.Fprintf(&b, "# HELP inventory_http_requests_in_flight Requests being handled.\n")
+	fmt.Fprintf(&b, "# TYPE inventory_http_requests_in_flight gauge\n")
+	fmt.Fprintf(&b, "inventory_http_requests_in_flight %d\n", m.inFlight)
+
+	fmt.Fprintf(&b, "# HELP inventory_store_errors_total Failures of the store, by method.\n")
+	fmt.Fprintf(&b, "# TYPE inventory_store_errors_total counter\n")
+	for _, op := range sortedKeys(m.storeErrors) {
+		fmt.Fprintf(&b, "inventory_store_errors_total{method=%q} %d\n", op, m.storeErrors[op])
+	}
+
+	fmt.Fprintf(&b, "# HELP inventory_cache_lookups_total Items looked up in the cache, by whether they were found.\n")
+	fmt.Fprintf(&b, "# TYPE inventory_cache_lookups_total counter\n")
+	fmt.Fprintf(&b, "inventory_cache_lookups_total{result=\"hit\"} %d\n", m.cacheHits)
+	fmt.Fprintf(&b, "inventory_cache_lookups_total{result=\"miss\"} %d\n", m.cacheMisses)
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// route returns the pattern path matches, for labelling metrics without
+// a label per item.
+func route(path string) string {
+	switch path {
+	case "/items", "/items:import", "/items:export", "/search", "/reservations", "/orders", "/events",
+		"/healthz", "/readyz", "/metrics", "/list", "/price", "/create", "/update", "/delete":
+		return path
+	}
+	if strings.HasPrefix(path, "/items/") {
+		if _, action := itemAction(path); action != "" {
+			return "/items/{name}/" + action
+		}
+		return "/items/{name}"
+	}
+	if strings.HasPrefix(path, "/reservations/") {
+		return "/reservations/{id}"
+	}
+	return "other"
+}
+
+// Instrument returns store with its failures counted in m.
+func (m *Metrics) Instrument(store InventoryStore) InventoryStore {
+	return &instrumented{store, m}
+}
+
+// instrumented is a store whose failures are counted.
+type instrumented struct {
+	InventoryStore
+	m *Metrics
+}
+
+func (s *instrumented) Get(ctx context.Context, name string) (Item, error) {
+	item, err := s.InventoryStore.Get(ctx, name)
+	s.m.storeError("Get", err)
+	return item, err
+}
+
+func (s *instrumented) List(ctx context.Context) ([]Item, error) {
+	items, err := s.InventoryStore.List(ctx)
+	s.m.storeError("List", err)
+	return items, err
+}
+
+func (s *instrumented) Query(ctx context.Context, q Query) ([]Item, error) {
+	items, err := s.InventoryStore.Query(ctx, q)
+	s.m.storeError("Query", err)
+	return items, err
+}
+
+func (s *instrumented) Search(ctx context.Context, q SearchQuery) (SearchResult, error) {
+	r, err := s.InventoryStore.Search(ctx, q)
+	s.m.storeError("Search", err)
+	return r, err
+}
+
+func (s *instrumented) Create(ctx context.Context, item Item) error {
+	err := s.InventoryStore.Create(ctx, item)
+	s.m.storeError("Create", err)
+	return err
+}
+
+func (s *instrumented) Update(ctx context.Context, item Item) error {
+	err := s.InventoryStore.Update(ctx, item)
+	s.m.storeError("Update", err)
+	return err
+}
+
+func (s *instrumented) Delete(ctx context.Context, name string, version int64) error {
+	err := s.InventoryStore.Delete(ctx, name, version)
+	s.m.storeError("Delete", err)
+	return err
+}
+
+func (s *instrumented) Reserve(ctx context.Context, lines []Line, expires time.Time) (Reservation, error) {
+	r, err := s.InventoryStore.Reserve(ctx, lines, expires)
+	s.m.storeError("Reserve", err)
+	return r, err
+}
+
+func (s *instrumented) Reservation(ctx context.Context, id string) (Reservation, error) {
+	r, err := s.InventoryStore.Reservation(ctx, id)
+	s.m.storeError("Reservation", err)
+	return r, err
+}
+
+func (s *instrumented) Release(ctx context.Context, id string) error {
+	err := s.InventoryStore.Release(ctx, id)
+	s.m.storeError("Release", err)
+	return err
+}
+
+func (s *instrumented) PlaceOrder(ctx context.Context, lines []Line, id string) (Order, error) {
+	o, err := s.InventoryStore.PlaceOrder(ctx, lines, id)
+	s.m.storeError("PlaceOrder", err)
+	return o, err
+}
+
+func (s *instrumented) History(ctx context.Context, name string) ([]Event, error) {
+	events, err := s.InventoryStore.History(ctx, name)
+	s.m.storeError("History", err)
+	return events, err
+}
+
+func (s *instrumented) Watch(ctx context.Context, fn func(Event)) error {
+	err := s.InventoryStore.Watch(ctx, fn)
+	s.m.storeError("Watch", err)
+	return err
+}
+
+func (s *instrumented) Ping(ctx context.Context) error {
+	err := ping(ctx, s.InventoryStore)
+	s.m.storeError("Ping", err)
+	return err
+}
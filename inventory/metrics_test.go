@@ -0,0 +1,63 @@
+package inventory
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	m := NewMetrics()
+	ts := httptest.NewServer((&Server{Store: m.Instrument(downStore{NewMemoryStore(DefaultItems...)}), Metrics: m}).Handler())
+	t.Cleanup(ts.Close)
+
+	do(t, ts, "GET", "/items", "")
+	do(t, ts, "GET", "/items", "")
+	do(t, ts, "GET", "/items/shoes", "") // The store fails.
+	do(t, ts, "GET", "/items/socks/history", "")
+	do(t, ts, "DELETE", "/reservations/nope", "") // Not found, which is no failure.
+	do(t, ts, "GET", "/readyz", "")
+	resp, body := do(t, ts, "GET", "/metrics", "")
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", ct)
+	}
+
+	for _, want := range []string{
+		`inventory_http_requests_total{route="/items",method="GET",status="200"} 2`,
+		`inventory_http_requests_total{route="/items/{name}",method="GET",status="500"} 1`,
+		`inventory_http_requests_total{route="/reservations/{id}",method="DELETE",status="404"} 1`,
+		`inventory_http_requests_total{route="/items/{name}/history",method="GET",status="200"} 1`,
+		`inventory_http_requests_total{route="/readyz",method="GET",status="503"} 1`,
+		`inventory_http_request_duration_seconds_bucket{route="/items",le="+Inf"} 2`,
+		`inventory_http_request_duration_seconds_count{route="/items"} 2`,
+		`inventory_http_requests_in_flight 1`, // The request for /metrics.
+		`inventory_store_errors_total{method="Get"} 1`,
+		`inventory_store_errors_total{method="Ping"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+	if strings.Contains(body, `method="Release"`) {
+		t.Error("ErrNotFound counted as a store failure")
+	}
+}
+
+func TestRoute(t *testing.T) {
+	t.Parallel()
+	for path, want := range map[string]string{
+		"/items":               "/items",
+		"/items/shoes":         "/items/{name}",
+		"/items/shoes/history": "/items/{name}/history",
+		"/items/shoes/restore": "/items/{name}/restore",
+		"/reservations/abc":    "/reservations/{id}",
+		"/items:export":        "/items:export",
+		"/favicon.ico":         "other",
+		"/items/a/b/c":         "/items/{name}",
+	} {
+		if got := route(path); got != want {
+			t.Errorf("route(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
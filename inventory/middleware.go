@@ -0,0 +1,135 @@
+package inventory
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+const maxRequestIDSize = 64 // Longest X-Request-ID accepted from a client.
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request whose context is ctx, or "" if
+// there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID reports whether a client's X-Request-ID is short and
+// plain enough to be logged and echoed back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDSize {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// observe wraps next so that every request has an ID, taken from its
+// X-Request-ID header if it has a usable one and sent back in the
+// response's; bodies larger than any handler accepts are refused; a panic
+// is logged and answered with a 500 rather than dropping the connection;
+// requests other than streams have s.RequestTimeout to finish; and every
+// request is counted in s.Metrics and logged to s.Logger, if they are set.
+func (s *Server) observe(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		id := req.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newID()
+		}
+		req = req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+		w.Header().Set("X-Request-ID", id)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		var done func(status int)
+		if s.Metrics != nil {
+			done = s.Metrics.begin(route(req.URL.Path), req.Method)
+		}
+
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				s.logger().Error("panic serving request", "request_id", id, "method", req.Method, "uri", req.URL.RequestURI(), "panic", v, "stack", string(debug.Stack()))
+				if !rec.wroteHeader {
+					writeError(rec, http.StatusInternalServerError, "internal error")
+				} else {
+					rec.status = http.StatusInternalServerError // Cut short; the client sees a truncated response.
+				}
+			}
+			if done != nil {
+				done(rec.status)
+			}
+			if s.Logger != nil {
+				s.Logger.Info("request",
+					"request_id", id,
+					"remote", req.RemoteAddr,
+					"method", req.Method,
+					"uri", req.URL.RequestURI(),
+					"status", rec.status,
+					"bytes", rec.written,
+					"duration_ms", float64(time.Since(start).Microseconds())/1000,
+				)
+			}
+		}()
+
+		if req.ContentLength > maxImportSize {
+			writeError(rec, http.StatusRequestEntityTooLarge, "request body is %d bytes; the limit is %d", req.ContentLength, maxImportSize)
+			return
+		}
+		req.Body = http.MaxBytesReader(rec, req.Body, maxImportSize)
+		if s.RequestTimeout > 0 && !streams(req.URL.Path) {
+			ctx, cancel := context.WithTimeout(req.Context(), s.RequestTimeout)
+			defer cancel()
+			req = req.WithContext(ctx)
+		}
+		next.ServeHTTP(rec, req)
+	})
+}
+
+// streams reports whether path is a route whose responses or requests are
+// streamed, and so may take as long as they need.
+func streams(path string) bool {
+	return path == "/events" || path == "/items:import" || path == "/items:export"
+}
+
+// statusRecorder notes the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	written     int64
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusRecorder) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
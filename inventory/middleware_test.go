@@ -0,0 +1,170 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestObserve(t *testing.T) {
+	t.Parallel()
+	var logs bytes.Buffer
+	s := &Server{Logger: slog.New(slog.NewJSONHandler(&logs, nil))}
+	h := s.observe(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/panic":
+			panic("boom")
+		case "/id":
+			w.Write([]byte(RequestID(req.Context())))
+		}
+	}))
+
+	tests := []struct {
+		path, requestID string
+		contentLength   int64
+		status          int
+		wantID          string // Empty for a generated ID.
+	}{
+		{"/id", "", 0, 200, ""},
+		{"/id", "abc-123", 0, 200, "abc-123"},
+		{"/id", "no spaces", 0, 200, ""},
+		{"/id", strings.Repeat("x", maxRequestIDSize+1), 0, 200, ""},
+		{"/panic", "p", 0, 500, "p"},
+		{"/id", "big", maxImportSize + 1, 413, "big"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.path, strings.NewReader(""))
+		req.ContentLength = tt.contentLength
+		if tt.requestID != "" {
+			req.Header.Set("X-Request-ID", tt.requestID)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		id := w.Header().Get("X-Request-ID")
+		if w.Code != tt.status || tt.wantID != "" && id != tt.wantID || tt.wantID == "" && len(id) != 16 {
+			t.Errorf("%s with ID %q: status %d, ID %q; want %d, %q", tt.path, tt.requestID, w.Code, id, tt.status, tt.wantID)
+		}
+		if tt.status == 200 && w.Body.String() != id {
+			t.Errorf("%s: handler saw request ID %q, response has %q", tt.path, w.Body.String(), id)
+		}
+		if tt.status != 200 && !strings.Contains(w.Body.String(), `"error"`) {
+			t.Errorf("%s: body %q is not a JSON error", tt.path, w.Body.String())
+		}
+	}
+
+	// Every request is logged, and the panic with its stack.
+	var requests, panics int
+	dec := json.NewDecoder(&logs)
+	for dec.More() {
+		var line struct {
+			Msg       string `json:"msg"`
+			RequestID string `json:"request_id"`
+			Status    int    `json:"status"`
+			Stack     string `json:"stack"`
+		}
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		switch line.Msg {
+		case "request":
+			requests++
+			if line.RequestID == "" || line.Status == 0 {
+				t.Errorf("access log line %+v", line)
+			}
+		case "panic serving request":
+			panics++
+			if line.RequestID != "p" || !strings.Contains(line.Stack, "middleware_test.go") {
+				t.Errorf("panic log line %+v", line)
+			}
+		}
+	}
+	if requests != len(tests) || panics != 1 {
+		t.Errorf("logged %d requests and %d panics, want %d and 1", requests, panics, len(tests))
+	}
+}
+
+func TestServe(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- Serve(ctx, NewHTTPServer("127.0.0.1:0", http.NotFoundHandler())) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Serve after a clean shutdown: %v", err)
+	}
+
+	if err := Serve(context.Background(), NewHTTPServer("127.0.0.1:-1", http.NotFoundHandler())); err == nil {
+		t.Error("Serve on a bad address succeeded")
+	}
+}
+
+// slowStore is a store that takes longer than RequestTimeout to answer
+// queries, and never answers gets.
+type slowStore struct{ InventoryStore }
+
+func (slowStore) Get(ctx context.Context, name string) (Item, error) {
+	<-ctx.Done()
+	return Item{}, ctx.Err()
+}
+
+func (s slowStore) Query(ctx context.Context, q Query) ([]Item, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(50 * time.Millisecond):
+	}
+	return s.InventoryStore.Query(ctx, q)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	t.Parallel()
+	s := &Server{Store: slowStore{NewMemoryStore(DefaultItems...)}, RequestTimeout: 10 * time.Millisecond}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+
+	for _, path := range []string{"/items/shoes", "/items"} {
+		resp, body := do(t, ts, "GET", path, "")
+		if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+			t.Errorf("GET %s from a slow store: status %d, Retry-After %q: %s", path, resp.StatusCode, resp.Header.Get("Retry-After"), body)
+		}
+	}
+	// Exports are streamed, so they are not cut short.
+	if resp, body := do(t, ts, "GET", "/items:export", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /items:export: status %d: %s", resp.StatusCode, body)
+	}
+	if got := statusOf(fmt.Errorf("%w: no primary", ErrUnavailable)); got != http.StatusServiceUnavailable {
+		t.Errorf("status of ErrUnavailable = %d, want 503", got)
+	}
+}
+
+func TestStoreErrorHidden(t *testing.T) {
+	t.Parallel()
+	var logs bytes.Buffer
+	s := &Server{Store: downStore{NewMemoryStore(DefaultItems...)}, Logger: slog.New(slog.NewJSONHandler(&logs, nil))}
+	req := httptest.NewRequest("GET", "/items/shoes", nil)
+	req.Header.Set("X-Request-ID", "fail-1")
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+
+	if want := `{"error":{"status":500,"message":"internal error"}}`; w.Code != 500 || strings.TrimSpace(w.Body.String()) != want {
+		t.Errorf("GET /items/shoes = %d %s, want 500 %s", w.Code, w.Body, want)
+	}
+	var logged bool
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]any
+		if json.Unmarshal([]byte(line), &entry) == nil && entry["msg"] == "store error" {
+			logged = entry["request_id"] == "fail-1" && entry["error"] == errDown.Error()
+		}
+	}
+	if !logged {
+		t.Errorf("store error not logged with its request ID:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,226 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is assumed when a price is given without a currency.
+const DefaultCurrency = "USD"
+
+// A currency describes how amounts of an ISO 4217 currency are written.
+type currency struct {
+	digits int    // Digits after the decimal point; the size of the minor unit.
+	symbol string // Written before the amount; the code is written after it if empty.
+}
+
+var currencies = map[string]currency{
+	"USD": {2, "$"},
+	"EUR": {2, "€"},
+	"GBP": {2, "£"},
+	"JPY": {0, "¥"},
+	"CAD": {2, "CA$"},
+	"CHF": {2, ""},
+}
+
+// Currencies returns the codes of the currencies prices may be in, in
+// order.
+func Currencies() []string {
+	return sortedKeys(currencies)
+}
+
+// Money is an exact, non-negative amount of a currency, counted in the
+// currency's minor unit (cents for USD).
+type Money struct {
+	Amount   int64  // Minor units.
+	Currency string // ISO 4217 code.
+}
+
+var (
+	errNegative = errors.New("amount must not be negative")
+	errTooLarge = errors.New("amount is too large")
+)
+
+// ParseMoney parses a decimal amount such as "19.99" of the given currency.
+// The amount may start with the currency's symbol but may not have a sign,
+// exponent, digit separators or spaces. Digits beyond the currency's minor
+// unit are rounded half to even.
+func ParseMoney(s, code string) (Money, error) {
+	cur, ok := currencies[code]
+	if !ok {
+		return Money{}, fmt.Errorf("unknown currency %q", code)
+	}
+	amount := s
+	if cur.symbol != "" {
+		amount = strings.TrimPrefix(amount, cur.symbol)
+	}
+	if strings.HasPrefix(amount, "-") {
+		return Money{}, errNegative
+	}
+	whole, frac, hasPoint := strings.Cut(amount, ".")
+	if whole == "" || hasPoint && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+
+	// Keep the digits that fit the minor unit and round away the rest.
+	kept, rest := frac, ""
+	if len(frac) > cur.digits {
+		kept, rest = frac[:cur.digits], frac[cur.digits:]
+	}
+	kept += strings.Repeat("0", cur.digits-len(kept))
+	var n int64
+	for _, c := range whole + kept {
+		d := int64(c - '0')
+		if n > (math.MaxInt64-d)/10 {
+			return Money{}, errTooLarge
+		}
+		n = n*10 + d
+	}
+	if roundsUp(rest, n%2 == 1) {
+		if n == math.MaxInt64 {
+			return Money{}, errTooLarge
+		}
+		n++
+	}
+	return Money{Amount: n, Currency: code}, nil
+}
+
+// MoneyFromFloat converts a binary floating-point amount, as found in JSON
+// numbers and older records, using its shortest decimal representation.
+func MoneyFromFloat(f float64, code string) (Money, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Money{}, fmt.Errorf("amount must be finite, got %v", f)
+	}
+	if f < 0 {
+		return Money{}, errNegative
+	}
+	return ParseMoney(strconv.FormatFloat(f, 'f', -1, 64), code)
+}
+
+// roundsUp reports whether discarding the digits rest rounds the kept part
+// up, rounding ties to even.
+func roundsUp(rest string, odd bool) bool {
+	if rest == "" || rest[0] < '5' {
+		return false
+	}
+	if rest[0] > '5' || strings.Trim(rest[1:], "0") != "" {
+		return true
+	}
+	return odd
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate reports whether m is a usable price.
+func (m Money) Validate() error {
+	if _, ok := currencies[m.Currency]; !ok {
+		return fmt.Errorf("unknown currency %q", m.Currency)
+	}
+	if m.Amount < 0 {
+		return errNegative
+	}
+	return nil
+}
+
+// Decimal returns the amount without a currency symbol, such as "19.99".
+func (m Money) Decimal() string {
+	digits := currencies[m.Currency].digits
+	s := strconv.FormatInt(m.Amount, 10)
+	if digits == 0 {
+		return s
+	}
+	if len(s) <= digits {
+		s = strings.Repeat("0", digits-len(s)+1) + s
+	}
+	return s[:len(s)-digits] + "." + s[len(s)-digits:]
+}
+
+// String formats m for people, such as "$19.99" or "19.99 CHF".
+func (m Money) String() string {
+	if symbol := currencies[m.Currency].symbol; symbol != "" {
+		return symbol + m.Decimal()
+	}
+	return m.Decimal() + " " + m.Currency
+}
+
+// moneyJSON is the JSON form of Money. The amount is a string so that it
+// survives decoders that read numbers as floats.
+type moneyJSON struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	amount, _ := json.Marshal(m.Decimal())
+	return json.Marshal(moneyJSON{amount, m.Currency})
+}
+
+// UnmarshalJSON accepts {"amount":"19.99","currency":"USD"}, with the amount
+// given as a string or number, or a bare string or number in the default
+// currency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	code := DefaultCurrency
+	if len(data) > 0 && data[0] == '{' {
+		var v moneyJSON
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&v); err != nil {
+			return err
+		}
+		if v.Amount == nil {
+			return errors.New("missing amount")
+		}
+		if v.Currency != "" {
+			code = v.Currency
+		}
+		data = bytes.TrimSpace(v.Amount)
+	}
+	var (
+		parsed Money
+		err    error
+	)
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parsed, err = ParseMoney(s, code)
+	} else {
+		// Parse the number's text rather than a float64 so it stays exact.
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid amount %s", data)
+		}
+		parsed, err = parseNumber(n.String(), code)
+	}
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// parseNumber parses a JSON number, which may have a sign or exponent.
+func parseNumber(s, code string) (Money, error) {
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return Money{}, fmt.Errorf("invalid amount %s", s)
+		}
+		return MoneyFromFloat(f, code)
+	}
+	return ParseMoney(s, code)
+}
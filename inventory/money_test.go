@@ -0,0 +1,104 @@
+package inventory
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, currency string
+		want         Money
+		ok           bool
+	}{
+		{"19.99", "USD", Money{1999, "USD"}, true},
+		{"$19.99", "USD", Money{1999, "USD"}, true},
+		{"19", "USD", Money{1900, "USD"}, true},
+		{"19.9", "USD", Money{1990, "USD"}, true},
+		{"0.125", "USD", Money{12, "USD"}, true},  // tie rounds to even
+		{"0.135", "USD", Money{14, "USD"}, true},  // tie rounds to even
+		{"0.1251", "USD", Money{13, "USD"}, true}, // above the tie
+		{"0.1249", "USD", Money{12, "USD"}, true},
+		{"500", "JPY", Money{500, "JPY"}, true},
+		{"500.5", "JPY", Money{500, "JPY"}, true},
+		{"92233720368547758.07", "USD", Money{math.MaxInt64, "USD"}, true},
+		{"92233720368547758.08", "USD", Money{}, false},
+		{"-1", "USD", Money{}, false},
+		{"+1", "USD", Money{}, false},
+		{"1e3", "USD", Money{}, false},
+		{"NaN", "USD", Money{}, false},
+		{"Inf", "USD", Money{}, false},
+		{"1,000", "USD", Money{}, false},
+		{" 1", "USD", Money{}, false},
+		{".5", "USD", Money{}, false},
+		{"5.", "USD", Money{}, false},
+		{"", "USD", Money{}, false},
+		{"1", "XXX", Money{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in, tt.currency)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseMoney(%q, %q) = %v, %v; want %v, ok=%v", tt.in, tt.currency, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMoneyFromFloat(t *testing.T) {
+	t.Parallel()
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), -0.01} {
+		if m, err := MoneyFromFloat(f, "USD"); err == nil {
+			t.Errorf("MoneyFromFloat(%v) = %v, want an error", f, m)
+		}
+	}
+	if m, err := MoneyFromFloat(19.99, "USD"); err != nil || m != (Money{1999, "USD"}) {
+		t.Errorf("MoneyFromFloat(19.99) = %v, %v", m, err)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{Money{1999, "USD"}, "$19.99"},
+		{Money{5, "USD"}, "$0.05"},
+		{Money{0, "EUR"}, "€0.00"},
+		{Money{500, "JPY"}, "¥500"},
+		{Money{1250, "CHF"}, "12.50 CHF"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	t.Parallel()
+	b, err := json.Marshal(Money{1999, "USD"})
+	if err != nil || string(b) != `{"amount":"19.99","currency":"USD"}` {
+		t.Errorf("Marshal = %s, %v", b, err)
+	}
+	for in, want := range map[string]Money{
+		`{"amount":"19.99","currency":"USD"}`: {1999, "USD"},
+		`{"amount":19.99,"currency":"EUR"}`:   {1999, "EUR"},
+		`{"amount":"500"}`:                    {50000, "USD"},
+		`"19.99"`:                             {1999, "USD"},
+		`19.99`:                               {1999, "USD"},
+		`1.999e1`:                             {1999, "USD"},
+	} {
+		var m Money
+		if err := json.Unmarshal([]byte(in), &m); err != nil || m != want {
+			t.Errorf("Unmarshal(%s) = %v, %v; want %v", in, m, err, want)
+		}
+	}
+	for _, in := range []string{`-1`, `"-1"`, `{"currency":"USD"}`, `{"amount":"1","currency":"usd"}`, `{"amount":"1","extra":1}`, `true`, `null`} {
+		var m Money
+		if err := json.Unmarshal([]byte(in), &m); err == nil && in != `null` {
+			t.Errorf("Unmarshal(%s) = %v, want an error", in, m)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/VahidBabaey/CloudComputing/inventory"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migrations is the collection recording which migrations have been
+// applied. It also holds the lock that lets one server at a time apply
+// them.
+const Migrations = "schema_migrations"
+
+const (
+	lockID    = "lock"
+	lockLease = 10 * time.Minute // Longer than any migration should take.
+	lockPoll  = time.Second      // How often a server waiting for the lock tries again.
+)
+
+// A Migration changes the database from one version of its schema to the
+// next. Up should be safe to run again if it fails part way. Down undoes
+// Up, and is nil if that cannot be done.
+type Migration struct {
+	Version     int
+	Description string
+	Up, Down    func(ctx context.Context, s *Store) error
+}
+
+// migrations are those registered, in order.
+var migrations []Migration
+
+// Register adds m to the migrations MigrateUp applies. Migrations are
+// applied in the order they are registered, which must be that of their
+// versions.
+func Register(m Migration) {
+	if m.Up == nil {
+		panic(fmt.Sprintf("mongostore: migration %d has no Up", m.Version))
+	}
+	if n := len(migrations); n > 0 && m.Version <= migrations[n-1].Version {
+		panic(fmt.Sprintf("mongostore: migration %d registered after %d", m.Version, migrations[n-1].Version))
+	}
+	migrations = append(migrations, m)
+}
+
+func init() {
+	Register(Migration{1, "index items, reservations and history", createIndexes, dropIndexes})
+	Register(Migration{2, "store prices as amounts in minor units with a currency", splitPrices, joinPrices})
+	Register(Migration{3, "seed the default items", seed, unseed})
+	Register(Migration{4, "index items for text search", createSearchIndex, dropSearchIndex})
+}
+
+// record is the document noting that a migration was applied.
+type record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	Applied     time.Time `bson:"applied"`
+}
+
+// A MigrationStatus says whether a migration has been applied.
+type MigrationStatus struct {
+	Version     int
+	Description string
+	Applied     time.Time // Zero if the migration is pending.
+	Unknown     bool      // Applied by a newer version of the server.
+}
+
+// records returns the migrations applied to the database, by version.
+func (s *Store) records(ctx context.Context) (map[int]record, error) {
+	cursor, err := s.db.Collection(Migrations).Find(ctx, bson.M{"_id": bson.M{"$ne": lockID}})
+	if err != nil {
+		return nil, err
+	}
+	var docs []record
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	applied := map[int]record{}
+	for _, r := range docs {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// status merges the registered migrations with those applied.
+func status(registered []Migration, applied map[int]record) []MigrationStatus {
+	var statuses []MigrationStatus
+	known := map[int]bool{}
+	for _, m := range registered {
+		known[m.Version] = true
+		statuses = append(statuses, MigrationStatus{Version: m.Version, Description: m.Description, Applied: applied[m.Version].Applied})
+	}
+	for _, r := range applied {
+		if !known[r.Version] {
+			statuses = append(statuses, MigrationStatus{Version: r.Version, Description: r.Description, Applied: r.Applied, Unknown: true})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses
+}
+
+// MigrationStatus lists the registered migrations, and any applied by a
+// newer version of the server, saying which have been applied.
+func (s *Store) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := s.records(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return status(migrations, applied), nil
+}
+
+// MigrateUp applies the migrations not yet applied, in order, returning
+// those it applied. It holds the migrations lock while it does, so that
+// servers starting together do not apply them twice.
+func (s *Store) MigrateUp(ctx context.Context) ([]Migration, error) {
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	applied, err := s.records(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := m.Up(ctx, s); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		r := record{Version: m.Version, Description: m.Description, Applied: time.Now().UTC()}
+		if _, err := s.db.Collection(Migrations).InsertOne(ctx, r); err != nil {
+			return done, fmt.Errorf("recording migration %d: %w", m.Version, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the last migration applied, returning it.
+func (s *Store) MigrateDown(ctx context.Context) (Migration, error) {
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return Migration{}, err
+	}
+	defer unlock()
+	applied, err := s.records(ctx)
+	if err != nil {
+		return Migration{}, err
+	}
+	last := -1
+	for v := range applied {
+		last = max(last, v)
+	}
+	if last < 0 {
+		return Migration{}, errors.New("no migrations have been applied")
+	}
+	var m Migration
+	for _, r := range migrations {
+		if r.Version == last {
+			m = r
+		}
+	}
+	switch {
+	case m.Up == nil:
+		return Migration{}, fmt.Errorf("migration %d (%s) was applied by a newer version of the server", last, applied[last].Description)
+	case m.Down == nil:
+		return Migration{}, fmt.Errorf("migration %d (%s) cannot be reverted", m.Version, m.Description)
+	}
+	if err := m.Down(ctx, s); err != nil {
+		return Migration{}, fmt.Errorf("reverting migration %d (%s): %w", m.Version, m.Description, err)
+	}
+	if _, err := s.db.Collection(Migrations).DeleteOne(ctx, bson.M{"_id": m.Version}); err != nil {
+		return Migration{}, fmt.Errorf("recording the revert of migration %d: %w", m.Version, err)
+	}
+	return m, nil
+}
+
+// lock takes the migrations lock, waiting until whoever holds it releases
+// it or their lease runs out, and returns the function that releases it.
+func (s *Store) lock(ctx context.Context) (func(), error) {
+	coll := s.db.Collection(Migrations)
+	owner := newID()
+	for {
+		// Taking an expired lock updates it; taking a missing one inserts
+		// it; and a held one fails to be inserted again.
+		now := time.Now()
+		_, err := coll.UpdateOne(ctx,
+			bson.M{"_id": lockID, "expires": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"owner": owner, "expires": now.Add(lockLease)}},
+			options.Update().SetUpsert(true))
+		if err == nil {
+			return func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				coll.DeleteOne(ctx, bson.M{"_id": lockID, "owner": owner})
+			}, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for the migrations lock: %w", ctx.Err())
+		case <-time.After(lockPoll):
+		}
+	}
+}
+
+// Codes of the errors MongoDB returns when an index exists with the same
+// name or keys as one being created, but different options.
+const (
+	indexOptionsConflict  = 85
+	indexKeySpecsConflict = 86
+)
+
+// uniqueNames makes sure the index on item names is unique, replacing the
+// plain index created by earlier versions. It fails, naming them, if items
+// already share a name; they must be merged or removed by hand.
+func (s *Store) uniqueNames(ctx context.Context) error {
+	model := mongo.IndexModel{Keys: bson.D{{Key: "item", Value: 1}}, Options: options.Index().SetUnique(true)}
+	_, err := s.collection.Indexes().CreateOne(ctx, model)
+	var ce mongo.CommandError
+	if errors.As(err, &ce) && (ce.Code == indexOptionsConflict || ce.Code == indexKeySpecsConflict) {
+		if _, err := s.collection.Indexes().DropOne(ctx, "item_1"); err != nil {
+			return err
+		}
+		_, err = s.collection.Indexes().CreateOne(ctx, model)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		names, dupErr := s.duplicates(ctx)
+		if dupErr != nil {
+			return err
+		}
+		return fmt.Errorf("items share names, so they cannot be given a unique index: %q", names)
+	}
+	return err
+}
+
+// duplicates returns the names of items that appear more than once.
+func (s *Store) duplicates(ctx context.Context) ([]string, error) {
+	cursor, err := s.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$item", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		Name string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(groups))
+	for i, g := range groups {
+		names[i] = g.Name
+	}
+	return names, nil
+}
+
+// createIndexes creates the indexes queries use, and the one expiring
+// reservations.
+func createIndexes(ctx context.Context, s *Store) error {
+	err := s.uniqueNames(ctx)
+	if err == nil {
+		_, err = s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "price.currency", Value: 1}, {Key: "price.amount", Value: 1}, {Key: "item", Value: 1}},
+		})
+	}
+	if err == nil {
+		_, err = s.reservations.Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "expires", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+			{Keys: bson.D{{Key: "lines.item", Value: 1}}},
+		})
+	}
+	if err == nil {
+		_, err = s.history.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "item", Value: 1}, {Key: "time", Value: 1}}})
+	}
+	return err
+}
+
+// indexNotFound is the code of the error for dropping a missing index.
+const indexNotFound = 27
+
+func dropIndexes(ctx context.Context, s *Store) error {
+	for _, index := range []struct {
+		coll *mongo.Collection
+		name string
+	}{
+		{s.collection, "item_1"},
+		{s.collection, "price.currency_1_price.amount_1_item_1"},
+		{s.reservations, "expires_1"},
+		{s.reservations, "lines.item_1"},
+		{s.history, "item_1_time_1"},
+	} {
+		_, err := index.coll.Indexes().DropOne(ctx, index.name)
+		var ce mongo.CommandError
+		if err != nil && !(errors.As(err, &ce) && ce.Code == indexNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitPrices converts the bare dollar amounts stored as prices by early
+// versions of the webserver to documents of cents and a currency.
+func splitPrices(ctx context.Context, s *Store) error {
+	cents := bson.M{"$toLong": bson.M{"$round": bson.A{bson.M{"$multiply": bson.A{"$price", 100}}, 0}}}
+	_, err := s.collection.UpdateMany(ctx, bson.M{"price": bson.M{"$type": "number"}}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"price": bson.M{"amount": cents, "currency": "USD"}}}},
+	})
+	return err
+}
+
+// joinPrices converts prices back to bare dollar amounts, which is only
+// possible if they are all in dollars.
+func joinPrices(ctx context.Context, s *Store) error {
+	n, err := s.collection.CountDocuments(ctx, bson.M{"price.currency": bson.M{"$exists": true, "$ne": "USD"}})
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("%d items are priced in currencies other than USD", n)
+	}
+	_, err = s.collection.UpdateMany(ctx, bson.M{"price.currency": "USD"}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"price": bson.M{"$divide": bson.A{"$price.amount", 100}}}}},
+	})
+	return err
+}
+
+func seed(ctx context.Context, s *Store) error {
+	return inventory.Seed(ctx, s, inventory.DefaultItems...)
+}
+
+// unseed deletes the default items that have not been changed since they
+// were created.
+func unseed(ctx context.Context, s *Store) error {
+	for _, item := range inventory.DefaultItems {
+		err := s.Delete(ctx, item.Name, 1)
+		if err != nil && !errors.Is(err, inventory.ErrNotFound) && !errors.Is(err, inventory.ErrVersionMismatch) {
+			return err
+		}
+	}
+	return nil
+}
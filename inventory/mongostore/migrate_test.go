@@ -0,0 +1,49 @@
+package mongostore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegister(t *testing.T) {
+	registered := migrations
+	t.Cleanup(func() { migrations = registered })
+	up := func(ctx context.Context, s *Store) error { return nil }
+
+	for i, m := range migrations {
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			t.Errorf("migration %d follows %d", m.Version, migrations[i-1].Version)
+		}
+	}
+	next := migrations[len(migrations)-1].Version + 1
+	Register(Migration{Version: next, Description: "next", Up: up})
+	for _, m := range []Migration{{Version: next, Up: up}, {Version: next - 1, Up: up}, {Version: next + 1}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register of migration %d after %d did not panic", m.Version, next)
+				}
+			}()
+			Register(m)
+		}()
+	}
+}
+
+func TestStatus(t *testing.T) {
+	t.Parallel()
+	up := func(ctx context.Context, s *Store) error { return nil }
+	then := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	registered := []Migration{{1, "one", up, nil}, {2, "two", up, nil}, {3, "three", up, nil}}
+	applied := map[int]record{1: {1, "one", then}, 3: {3, "three", then}, 4: {4, "four", then}}
+	want := []MigrationStatus{
+		{Version: 1, Description: "one", Applied: then},
+		{Version: 2, Description: "two"},
+		{Version: 3, Description: "three", Applied: then},
+		{Version: 4, Description: "four", Applied: then, Unknown: true},
+	}
+	if got := status(registered, applied); !reflect.DeepEqual(got, want) {
+		t.Errorf("status =\n%+v\nwant\n%+v", got, want)
+	}
+}
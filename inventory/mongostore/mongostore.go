@@ -0,0 +1,631 @@
+// Package mongostore keeps an inventory in MongoDB, as run by the lab8
+// Docker Compose file.
+package mongostore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/VahidBabaey/CloudComputing/inventory"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Where the items, reservations and history are kept.
+const (
+	Database     = "myDB"
+	Collection   = "inventory"
+	Reservations = "reservations"
+	History      = "history"
+)
+
+// A Store keeps items in the inventory collection, one document per item,
+// reservations in the reservations collection, and a document for every
+// change in the history collection. Changes run in transactions, so the
+// server must be a replica set member.
+type Store struct {
+	client       *mongo.Client
+	db           *mongo.Database
+	collection   *mongo.Collection
+	reservations *mongo.Collection
+	history      *mongo.Collection
+}
+
+// document is an item as stored in MongoDB.
+type document struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	Name        string             `bson:"item"`
+	Price       price              `bson:"price"`
+	Quantity    int64              `bson:"quantity"`
+	Description string             `bson:"description,omitempty"`
+	Category    string             `bson:"category,omitempty"`
+	Tags        []string           `bson:"tags,omitempty"`
+	Version     int64              `bson:"version"` // Missing, so zero, in documents written before versions.
+}
+
+func newDocument(item inventory.Item) document {
+	return document{Name: item.Name, Price: price(item.Price), Quantity: item.Quantity, Description: item.Description, Category: item.Category, Tags: item.Tags}
+}
+
+func (d document) item() inventory.Item {
+	return inventory.Item{Name: d.Name, Price: inventory.Money(d.Price), Quantity: d.Quantity, Description: d.Description, Category: d.Category, Tags: d.Tags, Version: d.Version}
+}
+
+// event is an inventory.Event as stored in MongoDB.
+type event struct {
+	ID   primitive.ObjectID `bson:"_id"`
+	Item string             `bson:"item"`
+	Op   string             `bson:"op"`
+	Time time.Time          `bson:"time"`
+	User string             `bson:"user,omitempty"`
+	Old  *document          `bson:"old,omitempty"`
+	New  *document          `bson:"new,omitempty"`
+}
+
+func (e event) event() inventory.Event {
+	ev := inventory.Event{Item: e.Item, Op: e.Op, Time: e.Time.UTC(), User: e.User}
+	if e.Old != nil {
+		old := e.Old.item()
+		ev.Old = &old
+	}
+	if e.New != nil {
+		item := e.New.item()
+		ev.New = &item
+	}
+	return ev
+}
+
+// reservation is a reservation as stored in MongoDB. A TTL index removes
+// it some time after it expires; until then queries skip it.
+type reservation struct {
+	ID      string           `bson:"_id"`
+	Lines   []inventory.Line `bson:"lines"`
+	Expires time.Time        `bson:"expires"`
+}
+
+// Open connects to the MongoDB server at uri, checking that it can be
+// reached. Options given after uri, such as the size of the connection
+// pool, override those in it. The database must have been brought up to
+// date with MigrateUp before the store is used.
+func Open(ctx context.Context, uri string, opts ...*options.ClientOptions) (*Store, error) {
+	client, err := mongo.Connect(ctx, append([]*options.ClientOptions{options.Client().ApplyURI(uri)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	db := client.Database(Database)
+	return &Store{client: client, db: db, collection: db.Collection(Collection), reservations: db.Collection(Reservations), history: db.Collection(History)}, nil
+}
+
+// transaction runs fn in a transaction, retrying it if it conflicts with
+// another.
+func (s *Store) transaction(ctx context.Context, fn func(ctx mongo.SessionContext) error) error {
+	session, err := s.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (any, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
+// reserved returns the quantity of each of the named items, or of every
+// item if names is nil, held by unexpired reservations.
+func (s *Store) reserved(ctx context.Context, names []string) (map[string]int64, error) {
+	match := bson.M{"expires": bson.M{"$gt": time.Now()}}
+	lineMatch := bson.M{}
+	if names != nil {
+		match["lines.item"] = bson.M{"$in": names}
+		lineMatch["lines.item"] = bson.M{"$in": names}
+	}
+	cursor, err := s.reservations.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: "$lines"}},
+		{{Key: "$match", Value: lineMatch}},
+		{{Key: "$group", Value: bson.M{"_id": "$lines.item", "total": bson.M{"$sum": "$lines.quantity"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var totals []struct {
+		Item  string `bson:"_id"`
+		Total int64  `bson:"total"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+	held := map[string]int64{}
+	for _, t := range totals {
+		held[t.Item] = t.Total
+	}
+	return held, nil
+}
+
+func (s *Store) Get(ctx context.Context, name string) (_ inventory.Item, err error) {
+	defer unavailable(&err)
+	var doc document
+	err = s.collection.FindOne(ctx, bson.M{"item": name}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return inventory.Item{}, fmt.Errorf("%w: %q", inventory.ErrNotFound, name)
+	}
+	if err != nil {
+		return inventory.Item{}, err
+	}
+	held, err := s.reserved(ctx, []string{name})
+	if err != nil {
+		return inventory.Item{}, err
+	}
+	item := doc.item()
+	item.Reserved = held[name]
+	return item, nil
+}
+
+func (s *Store) List(ctx context.Context) (_ []inventory.Item, err error) {
+	defer unavailable(&err)
+	cursor, err := s.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "item", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []document
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	held, err := s.reserved(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]inventory.Item, len(docs))
+	for i, doc := range docs {
+		items[i] = doc.item()
+		items[i].Reserved = held[doc.Name]
+	}
+	return items, nil
+}
+
+// Query reads a page of items with a range scan of the index on names or
+// on prices. Prices stored as bare doubles by earlier versions have no
+// amount field, so they sort first and never pass a price filter.
+func (s *Store) Query(ctx context.Context, q inventory.Query) (_ []inventory.Item, err error) {
+	defer unavailable(&err)
+	filter, sort := queryFilter(q)
+	cursor, err := s.collection.Find(ctx, filter, options.Find().SetSort(sort).SetLimit(int64(q.Limit)))
+	if err != nil {
+		return nil, err
+	}
+	var docs []document
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(docs))
+	for i, doc := range docs {
+		names[i] = doc.Name
+	}
+	held, err := s.reserved(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]inventory.Item, len(docs))
+	for i, doc := range docs {
+		items[i] = doc.item()
+		items[i].Reserved = held[doc.Name]
+	}
+	return items, nil
+}
+
+// queryFilter returns the filter and sort for q.
+func queryFilter(q inventory.Query) (filter, sort bson.D) {
+	var and bson.A
+	if q.Prefix != "" {
+		and = append(and, bson.M{"item": bson.M{"$regex": "^" + regexp.QuoteMeta(q.Prefix)}})
+	}
+	if m := q.MinPrice; m != nil {
+		and = append(and, bson.M{"price.currency": m.Currency, "price.amount": bson.M{"$gte": m.Amount}})
+	}
+	if m := q.MaxPrice; m != nil {
+		and = append(and, bson.M{"price.currency": m.Currency, "price.amount": bson.M{"$lte": m.Amount}})
+	}
+
+	dir, after := 1, "$gt"
+	if q.Desc {
+		dir, after = -1, "$lt"
+	}
+	if a := q.After; a != nil {
+		if q.Sort == inventory.SortPrice {
+			and = append(and, bson.M{"$or": bson.A{
+				bson.M{"price.currency": bson.M{after: a.Price.Currency}},
+				bson.M{"price.currency": a.Price.Currency, "price.amount": bson.M{after: a.Price.Amount}},
+				bson.M{"price.currency": a.Price.Currency, "price.amount": a.Price.Amount, "item": bson.M{after: a.Name}},
+			}})
+		} else {
+			and = append(and, bson.M{"item": bson.M{after: a.Name}})
+		}
+	}
+
+	filter = bson.D{}
+	if and != nil {
+		filter = bson.D{{Key: "$and", Value: and}}
+	}
+	sort = bson.D{{Key: "item", Value: dir}}
+	if q.Sort == inventory.SortPrice {
+		sort = bson.D{{Key: "price.currency", Value: dir}, {Key: "price.amount", Value: dir}, {Key: "item", Value: dir}}
+	}
+	return filter, sort
+}
+
+// record adds a change to the named item, made by the principal in ctx, to
+// the history.
+func (s *Store) record(ctx context.Context, name, op string, before, after *document) error {
+	p, _ := inventory.PrincipalFromContext(ctx)
+	if before != nil {
+		before.ID = primitive.NilObjectID
+	}
+	if after != nil {
+		after.ID = primitive.NilObjectID
+	}
+	_, err := s.history.InsertOne(ctx, event{ID: primitive.NewObjectID(), Item: name, Op: op, Time: time.Now().UTC(), User: p.Name, Old: before, New: after})
+	return err
+}
+
+func (s *Store) Create(ctx context.Context, item inventory.Item) (err error) {
+	defer unavailable(&err)
+	doc := newDocument(item)
+	doc.ID, doc.Version = primitive.NewObjectID(), 1
+	return s.transaction(ctx, func(ctx mongo.SessionContext) error {
+		_, err := s.collection.InsertOne(ctx, doc)
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("%w: %q", inventory.ErrConflict, item.Name)
+		}
+		if err != nil {
+			return err
+		}
+		return s.record(ctx, item.Name, inventory.OpCreate, nil, &doc)
+	})
+}
+
+// versioned returns the filter selecting the named item, at version if it
+// is not zero.
+func versioned(name string, version int64) bson.M {
+	filter := bson.M{"item": name}
+	if version != 0 {
+		filter["version"] = version
+	}
+	return filter
+}
+
+// missing returns the error for a write to the named item that matched
+// nothing: either the item does not exist or it is not at version.
+func (s *Store) missing(ctx context.Context, name string, version int64) error {
+	var doc document
+	err := s.collection.FindOne(ctx, bson.M{"item": name}).Decode(&doc)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments) || version == 0:
+		return fmt.Errorf("%w: %q", inventory.ErrNotFound, name)
+	case err != nil:
+		return err
+	}
+	return fmt.Errorf("%w: %q is at version %d, not %d", inventory.ErrVersionMismatch, name, doc.Version, version)
+}
+
+func (s *Store) Update(ctx context.Context, item inventory.Item) (err error) {
+	defer unavailable(&err)
+	return s.transaction(ctx, func(ctx mongo.SessionContext) error {
+		held, err := s.reserved(ctx, []string{item.Name})
+		if err != nil {
+			return err
+		}
+		if item.Quantity < held[item.Name] {
+			return insufficient(item.Name, held[item.Name], item.Quantity)
+		}
+		var old document
+		err = s.collection.FindOneAndUpdate(ctx, versioned(item.Name, item.Version), update(item)).Decode(&old)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return s.missing(ctx, item.Name, item.Version)
+		}
+		if err != nil {
+			return err
+		}
+		updated := newDocument(item)
+		updated.Version = old.Version + 1
+		return s.record(ctx, item.Name, inventory.OpUpdate, &old, &updated)
+	})
+}
+
+// update returns the update setting the fields of item other than its
+// name, removing those it leaves empty, and bumping its version.
+func update(item inventory.Item) bson.M {
+	set := bson.M{"price": price(item.Price), "quantity": item.Quantity}
+	unset := bson.M{}
+	optional := func(field string, value any, empty bool) {
+		if empty {
+			unset[field] = ""
+		} else {
+			set[field] = value
+		}
+	}
+	optional("description", item.Description, item.Description == "")
+	optional("category", item.Category, item.Category == "")
+	optional("tags", item.Tags, len(item.Tags) == 0)
+	u := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if len(unset) > 0 {
+		u["$unset"] = unset
+	}
+	return u
+}
+
+func (s *Store) Delete(ctx context.Context, name string, version int64) (err error) {
+	defer unavailable(&err)
+	return s.transaction(ctx, func(ctx mongo.SessionContext) error {
+		var old document
+		err := s.collection.FindOneAndDelete(ctx, versioned(name, version)).Decode(&old)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return s.missing(ctx, name, version)
+		}
+		if err != nil {
+			return err
+		}
+		return s.record(ctx, name, inventory.OpDelete, &old, nil)
+	})
+}
+
+// check makes sure that every line can be taken from the stock not already
+// reserved. It writes each item's document so that concurrent transactions
+// over the same item conflict, making one of them retry and see the
+// other's changes.
+func (s *Store) check(ctx mongo.SessionContext, lines []inventory.Line) error {
+	names := make([]string, len(lines))
+	for i, l := range lines {
+		names[i] = l.Item
+	}
+	held, err := s.reserved(ctx, names)
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		var doc document
+		err := s.collection.FindOneAndUpdate(ctx, bson.M{"item": l.Item}, bson.M{"$inc": bson.M{"stock_writes": 1}}).Decode(&doc)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("%w: %q", inventory.ErrNotFound, l.Item)
+		}
+		if err != nil {
+			return err
+		}
+		if have := doc.Quantity - held[l.Item]; have < l.Quantity {
+			return insufficient(l.Item, l.Quantity, have)
+		}
+	}
+	return nil
+}
+
+func (s *Store) Reserve(ctx context.Context, lines []inventory.Line, expires time.Time) (_ inventory.Reservation, err error) {
+	defer unavailable(&err)
+	r := reservation{ID: newID(), Lines: lines, Expires: expires.UTC()}
+	err = s.transaction(ctx, func(ctx mongo.SessionContext) error {
+		if err := s.check(ctx, lines); err != nil {
+			return err
+		}
+		_, err := s.reservations.InsertOne(ctx, r)
+		return err
+	})
+	if err != nil {
+		return inventory.Reservation{}, err
+	}
+	return inventory.Reservation(r), nil
+}
+
+func (s *Store) Reservation(ctx context.Context, id string) (_ inventory.Reservation, err error) {
+	defer unavailable(&err)
+	var r reservation
+	err = s.reservations.FindOne(ctx, bson.M{"_id": id, "expires": bson.M{"$gt": time.Now()}}).Decode(&r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return inventory.Reservation{}, fmt.Errorf("%w: %q", inventory.ErrNoReservation, id)
+	}
+	if err != nil {
+		return inventory.Reservation{}, err
+	}
+	r.Expires = r.Expires.UTC()
+	return inventory.Reservation(r), nil
+}
+
+func (s *Store) Release(ctx context.Context, id string) (err error) {
+	defer unavailable(&err)
+	result, err := s.reservations.DeleteOne(ctx, bson.M{"_id": id, "expires": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("%w: %q", inventory.ErrNoReservation, id)
+	}
+	return nil
+}
+
+func (s *Store) PlaceOrder(ctx context.Context, lines []inventory.Line, id string) (_ inventory.Order, err error) {
+	defer unavailable(&err)
+	var o inventory.Order
+	err = s.transaction(ctx, func(ctx mongo.SessionContext) error {
+		o = inventory.Order{ID: newID(), Placed: time.Now().UTC()}
+		orderLines := lines
+		if id != "" {
+			var r reservation
+			err := s.reservations.FindOneAndDelete(ctx, bson.M{"_id": id, "expires": bson.M{"$gt": time.Now()}}).Decode(&r)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return fmt.Errorf("%w: %q", inventory.ErrNoReservation, id)
+			}
+			if err != nil {
+				return err
+			}
+			orderLines = r.Lines
+		} else if err := s.check(ctx, lines); err != nil {
+			return err
+		}
+		for _, l := range orderLines {
+			var doc document
+			err := s.collection.FindOneAndUpdate(ctx, bson.M{"item": l.Item, "quantity": bson.M{"$gte": l.Quantity}},
+				bson.M{"$inc": bson.M{"quantity": -l.Quantity, "version": 1}}).Decode(&doc)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return s.short(ctx, l)
+			}
+			if err != nil {
+				return err
+			}
+			updated := doc
+			updated.Quantity, updated.Version = doc.Quantity-l.Quantity, doc.Version+1
+			if err := s.record(ctx, l.Item, inventory.OpOrder, &doc, &updated); err != nil {
+				return err
+			}
+			o.Lines = append(o.Lines, inventory.OrderLine{Line: l, Price: inventory.Money(doc.Price)})
+		}
+		return nil
+	})
+	if err != nil {
+		return inventory.Order{}, err
+	}
+	return o, nil
+}
+
+// short returns the error for an order line that matched no item with
+// enough stock: either the item does not exist or it has too little.
+func (s *Store) short(ctx context.Context, l inventory.Line) error {
+	var doc document
+	err := s.collection.FindOne(ctx, bson.M{"item": l.Item}).Decode(&doc)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return fmt.Errorf("%w: %q", inventory.ErrNotFound, l.Item)
+	case err != nil:
+		return err
+	}
+	return insufficient(l.Item, l.Quantity, doc.Quantity)
+}
+
+func (s *Store) History(ctx context.Context, name string) (_ []inventory.Event, err error) {
+	defer unavailable(&err)
+	cursor, err := s.history.Find(ctx, bson.M{"item": name}, options.Find().SetSort(bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []event
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("%w: %q", inventory.ErrNotFound, name)
+	}
+	events := make([]inventory.Event, len(docs))
+	for i, d := range docs {
+		events[i] = d.event()
+	}
+	return events, nil
+}
+
+// Watch follows the history collection with a change stream, so it sees
+// the changes made by every server using the database.
+func (s *Store) Watch(ctx context.Context, fn func(inventory.Event)) (err error) {
+	defer unavailable(&err)
+	stream, err := s.history.Watch(ctx, mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}})
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+	for stream.Next(ctx) {
+		var change struct {
+			Event event `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&change); err != nil {
+			return err
+		}
+		fn(change.Event.event())
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return stream.Err()
+}
+
+// unavailable wraps *err in inventory.ErrUnavailable if it means that the
+// server could not be reached or did not answer in time, so that clients
+// are told to try again rather than that the request failed.
+func unavailable(err *error) {
+	if e := *err; e != nil && (mongo.IsTimeout(e) || mongo.IsNetworkError(e) || errors.Is(e, mongo.ErrClientDisconnected)) {
+		*err = fmt.Errorf("%w: %v", inventory.ErrUnavailable, e)
+	}
+}
+
+func insufficient(name string, want, have int64) error {
+	return fmt.Errorf("%w of %q: want %d, have %d", inventory.ErrInsufficientStock, name, want, have)
+}
+
+// newID returns a random identifier for a reservation or order.
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Ping checks that the primary can be reached, for readiness checks.
+func (s *Store) Ping(ctx context.Context) (err error) {
+	defer unavailable(&err)
+	return s.client.Ping(ctx, readpref.Primary())
+}
+
+// Close disconnects from the server.
+func (s *Store) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.client.Disconnect(ctx)
+}
+
+// price is the BSON form of inventory.Money: a document holding the amount
+// in minor units, so that prices can be compared and summed inside
+// MongoDB, and the currency code.
+type price inventory.Money
+
+type priceDocument struct {
+	Amount   int64  `bson:"amount"`
+	Currency string `bson:"currency"`
+}
+
+func (p price) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(priceDocument{p.Amount, p.Currency})
+}
+
+// UnmarshalBSONValue reads {amount, currency} documents and the bare double
+// dollar amounts stored by earlier versions of the webserver.
+func (p *price) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+	var m inventory.Money
+	switch t {
+	case bsontype.EmbeddedDocument:
+		var v priceDocument
+		if err := raw.Unmarshal(&v); err != nil {
+			return err
+		}
+		m = inventory.Money{Amount: v.Amount, Currency: v.Currency}
+		if err := m.Validate(); err != nil {
+			return err
+		}
+	case bsontype.Double:
+		var err error
+		if m, err = inventory.MoneyFromFloat(raw.Double(), inventory.DefaultCurrency); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cannot decode %v as a price", t)
+	}
+	*p = price(m)
+	return nil
+}
@@ -0,0 +1,34 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/VahidBabaey/CloudComputing/inventory"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestUnavailable(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{fmt.Errorf("%w: %q", inventory.ErrNotFound, "shoes"), false},
+		{mongo.CommandError{Code: 11000, Message: "duplicate key"}, false},
+		{context.DeadlineExceeded, true},
+		{fmt.Errorf("finding: %w", context.DeadlineExceeded), true},
+		{mongo.CommandError{Message: "connection reset", Labels: []string{"NetworkError"}}, true},
+		{mongo.ErrClientDisconnected, true},
+	}
+	for _, tt := range tests {
+		err := tt.err
+		unavailable(&err)
+		if got := errors.Is(err, inventory.ErrUnavailable); got != tt.want {
+			t.Errorf("unavailable(%v) = %v; want ErrUnavailable: %v", tt.err, err, tt.want)
+		}
+	}
+}
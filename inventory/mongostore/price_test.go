@@ -0,0 +1,34 @@
+package mongostore
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VahidBabaey/CloudComputing/inventory"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPriceBSON(t *testing.T) {
+	t.Parallel()
+	want := inventory.Item{Name: "shoes", Price: inventory.Money{Amount: 1999, Currency: "USD"}}
+	b, err := bson.Marshal(document{Name: want.Name, Price: price(want.Price)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc document
+	if err := bson.Unmarshal(b, &doc); err != nil || !reflect.DeepEqual(doc.item(), want) {
+		t.Errorf("round trip gave %v, %v; want %v", doc.item(), err, want)
+	}
+
+	// Documents written before prices were exact hold a double.
+	b, _ = bson.Marshal(bson.M{"item": "shoes", "price": 19.99})
+	if err := bson.Unmarshal(b, &doc); err != nil || !reflect.DeepEqual(doc.item(), want) {
+		t.Errorf("legacy price gave %v, %v; want %v", doc.item(), err, want)
+	}
+	for _, bad := range []any{-1.0, bson.M{"amount": int64(-1), "currency": "USD"}, bson.M{"amount": int64(1), "currency": "XXX"}, "19.99"} {
+		b, _ = bson.Marshal(bson.M{"item": "shoes", "price": bad})
+		if err := bson.Unmarshal(b, &doc); err == nil {
+			t.Errorf("decoded price %v as %v", bad, doc.Price)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package mongostore
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VahidBabaey/CloudComputing/inventory"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestQueryFilter(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		q            inventory.Query
+		filter, sort bson.D
+	}{
+		{
+			inventory.Query{Sort: inventory.SortName},
+			bson.D{},
+			bson.D{{Key: "item", Value: 1}},
+		},
+		{
+			inventory.Query{Sort: inventory.SortName, Desc: true, Prefix: "a.b", After: &inventory.Item{Name: "a.bc"}},
+			bson.D{{Key: "$and", Value: bson.A{
+				bson.M{"item": bson.M{"$regex": `^a\.b`}},
+				bson.M{"item": bson.M{"$lt": "a.bc"}},
+			}}},
+			bson.D{{Key: "item", Value: -1}},
+		},
+		{
+			inventory.Query{
+				Sort:     inventory.SortPrice,
+				MaxPrice: &inventory.Money{Amount: 500, Currency: "USD"},
+				After:    &inventory.Item{Name: "socks", Price: inventory.Money{Amount: 100, Currency: "USD"}},
+			},
+			bson.D{{Key: "$and", Value: bson.A{
+				bson.M{"price.currency": "USD", "price.amount": bson.M{"$lte": int64(500)}},
+				bson.M{"$or": bson.A{
+					bson.M{"price.currency": bson.M{"$gt": "USD"}},
+					bson.M{"price.currency": "USD", "price.amount": bson.M{"$gt": int64(100)}},
+					bson.M{"price.currency": "USD", "price.amount": int64(100), "item": bson.M{"$gt": "socks"}},
+				}},
+			}}},
+			bson.D{{Key: "price.currency", Value: 1}, {Key: "price.amount", Value: 1}, {Key: "item", Value: 1}},
+		},
+	}
+	for _, tt := range tests {
+		filter, sort := queryFilter(tt.q)
+		if !reflect.DeepEqual(filter, tt.filter) || !reflect.DeepEqual(sort, tt.sort) {
+			t.Errorf("queryFilter(%+v) = %v, %v; want %v, %v", tt.q, filter, sort, tt.filter, tt.sort)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/VahidBabaey/CloudComputing/inventory"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// searchIndex is the name of the text index on items, weighted like the
+// ranking of the in-memory stores.
+const searchIndex = "search"
+
+// prefixScore is the score of an item found by a prefix of one of its
+// words rather than by a whole word.
+const prefixScore = 0.5
+
+// createSearchIndex creates the text index Search uses. Words are not
+// stemmed, so that they match the same way as in the in-memory stores.
+func createSearchIndex(ctx context.Context, s *Store) error {
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "item", Value: "text"}, {Key: "tags", Value: "text"}, {Key: "category", Value: "text"}, {Key: "description", Value: "text"}},
+		Options: options.Index().SetName(searchIndex).SetDefaultLanguage("none").
+			SetWeights(bson.M{"item": 3, "tags": 2, "category": 2, "description": 1}),
+	})
+	return err
+}
+
+func dropSearchIndex(ctx context.Context, s *Store) error {
+	_, err := s.collection.Indexes().DropOne(ctx, searchIndex)
+	var ce mongo.CommandError
+	if err != nil && !(errors.As(err, &ce) && ce.Code == indexNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Search finds items with the text index, ranked by MongoDB's text score.
+// The index only matches whole words, so if no item has any of them
+// Search looks for items with words starting with them instead. Unlike
+// the in-memory stores, it does not match words with typos.
+func (s *Store) Search(ctx context.Context, q inventory.SearchQuery) (_ inventory.SearchResult, err error) {
+	defer unavailable(&err)
+	words := strings.FieldsFunc(strings.ToLower(q.Text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	if len(words) == 0 {
+		return s.search(ctx, q, nil, nil)
+	}
+	// Joined words have none of the phrases or negations of $search.
+	result, err := s.search(ctx, q, bson.M{"$text": bson.M{"$search": strings.Join(words, " ")}}, bson.M{"$meta": "textScore"})
+	if err != nil || result.Total > 0 {
+		return result, err
+	}
+	for i, w := range words {
+		words[i] = regexp.QuoteMeta(w)
+	}
+	prefix := primitive.Regex{Pattern: `\b(?:` + strings.Join(words, "|") + `)`, Options: "i"}
+	return s.search(ctx, q, bson.M{"$or": bson.A{
+		bson.M{"item": prefix}, bson.M{"tags": prefix}, bson.M{"category": prefix}, bson.M{"description": prefix},
+	}}, bson.M{"$literal": prefixScore})
+}
+
+// search runs q, finding items by text with match and scoring them with
+// score; both are nil if q has no text.
+func (s *Store) search(ctx context.Context, q inventory.SearchQuery, match, score bson.M) (inventory.SearchResult, error) {
+	filter := bson.A{}
+	if match != nil {
+		filter = append(filter, match)
+	}
+	if q.Category != "" {
+		filter = append(filter, bson.M{"category": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(q.Category) + "$", Options: "i"}})
+	}
+	if len(q.Tags) > 0 {
+		filter = append(filter, bson.M{"tags": bson.M{"$all": q.Tags}})
+	}
+	pipeline := mongo.Pipeline{}
+	if len(filter) > 0 {
+		// $text must be in the first stage.
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"$and": filter}}})
+	}
+	order := bson.D{{Key: "item", Value: 1}}
+	if score != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$addFields", Value: bson.M{"score": score}}})
+		order = bson.D{{Key: "score", Value: -1}, {Key: "item", Value: 1}}
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$facet", Value: bson.M{
+		"hits": bson.A{
+			bson.M{"$sort": order},
+			bson.M{"$skip": q.Offset},
+			bson.M{"$limit": q.Limit},
+		},
+		"total": bson.A{bson.M{"$count": "n"}},
+		"category": bson.A{
+			bson.M{"$match": bson.M{"category": bson.M{"$nin": bson.A{nil, ""}}}},
+			bson.M{"$group": bson.M{"_id": "$category", "n": bson.M{"$sum": 1}}},
+		},
+		"price": bson.A{
+			bson.M{"$group": bson.M{"_id": bson.M{"currency": "$price.currency", "bucket": bucket()}, "n": bson.M{"$sum": 1}}},
+		},
+	}}})
+
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return inventory.SearchResult{}, err
+	}
+	var facets []struct {
+		Hits []struct {
+			document `bson:",inline"`
+			Score    float64 `bson:"score"`
+		} `bson:"hits"`
+		Total    []struct{ N int } `bson:"total"`
+		Category []struct {
+			Value string `bson:"_id"`
+			N     int    `bson:"n"`
+		} `bson:"category"`
+		Price []struct {
+			Value struct {
+				Currency string `bson:"currency"`
+				Bucket   int    `bson:"bucket"`
+			} `bson:"_id"`
+			N int `bson:"n"`
+		} `bson:"price"`
+	}
+	if err := cursor.All(ctx, &facets); err != nil {
+		return inventory.SearchResult{}, err
+	}
+	f := facets[0]
+
+	names := make([]string, len(f.Hits))
+	for i, h := range f.Hits {
+		names[i] = h.Name
+	}
+	held, err := s.reserved(ctx, names)
+	if err != nil {
+		return inventory.SearchResult{}, err
+	}
+	result := inventory.SearchResult{Hits: []inventory.Hit{}, Facets: inventory.Facets{Category: []inventory.FacetCount{}, Price: []inventory.FacetCount{}}}
+	if len(f.Total) > 0 {
+		result.Total = f.Total[0].N
+	}
+	for _, h := range f.Hits {
+		item := h.item()
+		item.Reserved = held[h.Name]
+		result.Hits = append(result.Hits, inventory.Hit{Item: item, Score: h.Score})
+	}
+	sort.Slice(f.Category, func(i, j int) bool { return f.Category[i].Value < f.Category[j].Value })
+	for _, c := range f.Category {
+		result.Facets.Category = append(result.Facets.Category, inventory.FacetCount{Value: c.Value, Count: c.N})
+	}
+	// Prices are in order of currency and then of bucket, as in the
+	// in-memory stores, not of their labels.
+	sort.Slice(f.Price, func(i, j int) bool {
+		a, b := f.Price[i].Value, f.Price[j].Value
+		if a.Currency != b.Currency {
+			return a.Currency < b.Currency
+		}
+		return a.Bucket < b.Bucket
+	})
+	for _, p := range f.Price {
+		result.Facets.Price = append(result.Facets.Price, inventory.FacetCount{Value: inventory.PriceBucket(p.Value.Currency, p.Value.Bucket), Count: p.N})
+	}
+	return result, nil
+}
+
+// bucket returns the expression for the index of the price bucket of an
+// item: the number of bounds, for its currency, that its price reaches.
+func bucket() bson.M {
+	var branches bson.A
+	for _, code := range inventory.Currencies() {
+		branches = append(branches, bson.M{"case": bson.M{"$eq": bson.A{"$price.currency", code}}, "then": inventory.PriceBounds(code)})
+	}
+	return bson.M{"$size": bson.M{"$filter": bson.M{
+		"input": bson.M{"$switch": bson.M{"branches": branches, "default": inventory.PriceBounds("")}},
+		"cond":  bson.M{"$lte": bson.A{"$$this", "$price.amount"}},
+	}}}
+}
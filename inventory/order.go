@@ -0,0 +1,94 @@
+package inventory
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	DefaultReservationTTL = 15 * time.Minute // How long stock is held when no TTL is given.
+	MaxReservationTTL     = 24 * time.Hour
+)
+
+// ErrInsufficientStock is returned when there is not enough of an item on
+// hand, less what is reserved, to reserve or order.
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// ErrNoReservation is returned for a reservation that does not exist or
+// has expired.
+var ErrNoReservation = errors.New("no such reservation")
+
+// A Line asks for a quantity of one item.
+type Line struct {
+	Item     string `json:"item"`
+	Quantity int64  `json:"quantity"`
+}
+
+// A Reservation holds stock for a while so that it can be ordered later.
+type Reservation struct {
+	ID      string    `json:"id"`
+	Lines   []Line    `json:"lines"`
+	Expires time.Time `json:"expires"`
+}
+
+// An OrderLine is a line of a placed order, priced when it was placed.
+type OrderLine struct {
+	Line
+	Price Money `json:"price"` // Price of each unit.
+}
+
+// An Order is stock taken out of the inventory.
+type Order struct {
+	ID     string      `json:"id"`
+	Lines  []OrderLine `json:"lines"`
+	Placed time.Time   `json:"placed"`
+}
+
+// insufficient returns the error for an order or reservation of want of an
+// item of which only have are available.
+func insufficient(name string, want, have int64) error {
+	return fmt.Errorf("%w of %q: want %d, have %d", ErrInsufficientStock, name, want, have)
+}
+
+func noReservation(id string) error { return fmt.Errorf("%w: %q", ErrNoReservation, id) }
+
+// MergeLines validates lines and combines those for the same item,
+// keeping the order in which items first appear.
+func MergeLines(lines []Line) ([]Line, error) {
+	if len(lines) == 0 {
+		return nil, errors.New("no lines")
+	}
+	var merged []Line
+	index := map[string]int{}
+	for _, l := range lines {
+		if l.Item == "" {
+			return nil, errors.New("line without an item")
+		}
+		if l.Quantity <= 0 {
+			return nil, fmt.Errorf("quantity of %q must be positive, got %d", l.Item, l.Quantity)
+		}
+		if i, ok := index[l.Item]; ok {
+			if merged[i].Quantity > math.MaxInt64-l.Quantity {
+				return nil, fmt.Errorf("total quantity of %q is too large", l.Item)
+			}
+			merged[i].Quantity += l.Quantity
+			continue
+		}
+		index[l.Item] = len(merged)
+		merged = append(merged, l)
+	}
+	return merged, nil
+}
+
+// newID returns a random identifier for a reservation or order.
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
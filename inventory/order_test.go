@@ -0,0 +1,238 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testOrders checks reservations and orders against a store holding 10
+// shoes at $50.00 and 100 socks at $5.00, whose clock is *now.
+func testOrders(t *testing.T, s InventoryStore, now *time.Time) {
+	t.Helper()
+	ctx := context.Background()
+	expires := now.Add(time.Minute)
+
+	checkStock := func(name string, quantity, reserved int64) {
+		t.Helper()
+		item, err := s.Get(ctx, name)
+		if err != nil || item.Quantity != quantity || item.Reserved != reserved || item.Available() != quantity-reserved {
+			t.Errorf("%s: got %+v, %v; want quantity %d, reserved %d", name, item, err, quantity, reserved)
+		}
+	}
+
+	r, err := s.Reserve(ctx, []Line{{"shoes", 4}, {"socks", 10}}, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStock("shoes", 10, 4)
+	if got, err := s.Reservation(ctx, r.ID); err != nil || !reflect.DeepEqual(got, r) {
+		t.Errorf("Reservation = %v, %v; want %v", got, err, r)
+	}
+
+	// Only 6 shoes are left to reserve or order.
+	if _, err := s.Reserve(ctx, []Line{{"shoes", 7}}, expires); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("reserving more than is available: got %v, want ErrInsufficientStock", err)
+	}
+	if _, err := s.PlaceOrder(ctx, []Line{{"socks", 1}, {"shoes", 7}}, ""); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("ordering more than is available: got %v, want ErrInsufficientStock", err)
+	}
+	checkStock("socks", 100, 10) // The whole order failed.
+	if err := s.Update(ctx, Item{Name: "shoes", Price: Money{5000, "USD"}, Quantity: 3}); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("updating below the reserved quantity: got %v, want ErrInsufficientStock", err)
+	}
+	if _, err := s.PlaceOrder(ctx, []Line{{"hats", 1}}, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ordering a missing item: got %v, want ErrNotFound", err)
+	}
+
+	o, err := s.PlaceOrder(ctx, []Line{{"shoes", 6}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []OrderLine{{Line{"shoes", 6}, Money{5000, "USD"}}}; !reflect.DeepEqual(o.Lines, want) {
+		t.Errorf("order lines are %v, want %v", o.Lines, want)
+	}
+	checkStock("shoes", 4, 4)
+
+	o, err = s.PlaceOrder(ctx, nil, r.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(o.Lines) != 2 || o.Lines[1] != (OrderLine{Line{"socks", 10}, Money{500, "USD"}}) {
+		t.Errorf("order from a reservation has lines %v", o.Lines)
+	}
+	checkStock("shoes", 0, 0)
+	checkStock("socks", 90, 0)
+	if _, err := s.PlaceOrder(ctx, nil, r.ID); !errors.Is(err, ErrNoReservation) {
+		t.Errorf("ordering a reservation twice: got %v, want ErrNoReservation", err)
+	}
+
+	// Released and expired reservations give their stock back.
+	r, err = s.Reserve(ctx, []Line{{"socks", 50}}, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Release(ctx, r.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Release(ctx, r.ID); !errors.Is(err, ErrNoReservation) {
+		t.Errorf("releasing a reservation twice: got %v, want ErrNoReservation", err)
+	}
+	r, err = s.Reserve(ctx, []Line{{"socks", 90}}, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	*now = expires
+	checkStock("socks", 90, 0)
+	if _, err := s.PlaceOrder(ctx, nil, r.ID); !errors.Is(err, ErrNoReservation) {
+		t.Errorf("ordering an expired reservation: got %v, want ErrNoReservation", err)
+	}
+	if _, err := s.Reserve(ctx, []Line{{"socks", 90}}, expires.Add(time.Minute)); err != nil {
+		t.Errorf("reserving stock freed by expiry: %v", err)
+	}
+
+	// A reserved item deleted, or deleted and created again with less
+	// stock, cannot be ordered.
+	if err := s.Update(ctx, Item{Name: "shoes", Price: Money{5000, "USD"}, Quantity: 4}); err != nil {
+		t.Fatal(err)
+	}
+	r, err = s.Reserve(ctx, []Line{{"shoes", 4}}, expires.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "shoes", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PlaceOrder(ctx, nil, r.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ordering a reserved item since deleted: got %v, want ErrNotFound", err)
+	}
+	if err := s.Create(ctx, Item{Name: "shoes", Price: Money{5000, "USD"}, Quantity: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PlaceOrder(ctx, nil, r.ID); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("ordering a reserved item since created again with less stock: got %v, want ErrInsufficientStock", err)
+	}
+	checkStock("shoes", 2, 4)
+}
+
+// clock returns a time, starting at the real one, and a function reading
+// it, for state.now.
+func clock() (*time.Time, func() time.Time) {
+	now := time.Now().UTC().Round(0)
+	return &now, func() time.Time { return now }
+}
+
+func TestMemoryStoreOrders(t *testing.T) {
+	t.Parallel()
+	s := NewMemoryStore(DefaultItems...)
+	now, f := clock()
+	s.state.now = f
+	testOrders(t, s, now)
+}
+
+func TestFileStoreOrders(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	s := newFileStore(t)
+	now, f := clock()
+	s.state.now = f
+	if err := Seed(ctx, s, DefaultItems...); err != nil {
+		t.Fatal(err)
+	}
+	testOrders(t, s, now)
+
+	// Reservations survive a restart, through both the snapshot and the log.
+	if err := s.Create(ctx, Item{Name: "hats", Price: Money{1000, "USD"}, Quantity: 10}); err != nil {
+		t.Fatal(err)
+	}
+	s.snapshotEvery = 1
+	r, err := s.Reserve(ctx, []Line{{"hats", 2}}, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.snapshotEvery = snapshotEvery
+	if _, err := s.Reserve(ctx, []Line{{"hats", 3}}, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	s = reopen(t, s)
+	s.state.now = f
+	if _, err := s.Reservation(ctx, r.ID); err != nil {
+		t.Errorf("reservation lost on reopening: %v", err)
+	}
+	for name, want := range map[string]int64{"hats": 5, "socks": 90} {
+		if item, _ := s.Get(ctx, name); item.Reserved != want {
+			t.Errorf("after reopening %d %s are reserved, want %d", item.Reserved, name, want)
+		}
+	}
+}
+
+func TestMergeLines(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		lines []Line
+		want  []Line // nil for an error
+	}{
+		{[]Line{{"shoes", 1}, {"socks", 2}, {"shoes", 3}}, []Line{{"shoes", 4}, {"socks", 2}}},
+		{nil, nil},
+		{[]Line{{"", 1}}, nil},
+		{[]Line{{"shoes", 0}}, nil},
+		{[]Line{{"shoes", -1}}, nil},
+		{[]Line{{"shoes", math.MaxInt64 - 1}, {"shoes", 1}}, []Line{{"shoes", math.MaxInt64}}},
+		{[]Line{{"shoes", math.MaxInt64}, {"shoes", 1}}, nil}, // Would overflow.
+	}
+	for _, tt := range tests {
+		got, err := MergeLines(tt.lines)
+		if (err != nil) != (tt.want == nil) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MergeLines(%v) = %v, %v; want %v", tt.lines, got, err, tt.want)
+		}
+	}
+}
+
+func TestOrdersAPI(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, false)
+
+	resp, body := do(t, ts, "POST", "/reservations", `{"lines":[{"item":"shoes","quantity":3}],"ttl":"1h"}`)
+	var r Reservation
+	if resp.StatusCode != 201 || json.Unmarshal([]byte(body), &r) != nil || resp.Header.Get("Location") != "/reservations/"+r.ID {
+		t.Fatalf("POST /reservations = %d %s", resp.StatusCode, body)
+	}
+	if d := time.Until(r.Expires); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("reservation expires in %v, want 1h", d)
+	}
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		want               string
+	}{
+		{"GET", "/reservations/" + r.ID, "", 200, ""},
+		{"GET", "/items/shoes", "", 200, `{"name":"shoes","price":{"amount":"50.00","currency":"USD"},"quantity":10,"reserved":3,"version":1}`},
+		{"POST", "/reservations", `{"lines":[{"item":"shoes","quantity":8}]}`, 409, `{"error":{"status":409,"message":"insufficient stock of \"shoes\": want 8, have 7"}}`},
+		{"POST", "/reservations", `{"lines":[{"item":"shoes","quantity":1}],"ttl":"48h"}`, 400, ""},
+		{"POST", "/reservations", `{"lines":[]}`, 400, `{"error":{"status":400,"message":"no lines"}}`},
+		{"POST", "/orders", `{"lines":[{"item":"socks","quantity":1},{"item":"shoes","quantity":8}]}`, 409, ""},
+		{"GET", "/items/socks", "", 200, `{"name":"socks","price":{"amount":"5.00","currency":"USD"},"quantity":100,"reserved":0,"version":1}`},
+		{"POST", "/orders", `{"lines":[{"item":"socks","quantity":1}],"reservation":"` + r.ID + `"}`, 400, ""},
+		{"POST", "/orders", `{"reservation":"` + r.ID + `"}`, 201, ""},
+		{"GET", "/items/shoes", "", 200, `{"name":"shoes","price":{"amount":"50.00","currency":"USD"},"quantity":7,"reserved":0,"version":2}`},
+		{"GET", "/reservations/" + r.ID, "", 404, ""},
+		{"DELETE", "/reservations/" + r.ID, "", 404, ""},
+		{"POST", "/orders", `{"lines":[{"item":"hats","quantity":1}]}`, 404, ""},
+		{"GET", "/orders", "", 405, ""},
+	}
+	for _, tt := range tests {
+		resp, body := do(t, ts, tt.method, tt.path, tt.body)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: got status %d, want %d (%s)", tt.method, tt.path, resp.StatusCode, tt.status, body)
+			continue
+		}
+		if tt.want != "" && body != tt.want+"\n" {
+			t.Errorf("%s %s: got %s, want %s", tt.method, tt.path, body, tt.want)
+		}
+	}
+}
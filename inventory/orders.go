@@ -0,0 +1,111 @@
+package inventory
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A reservationRequest is the body of a POST to /reservations.
+type reservationRequest struct {
+	Lines []Line `json:"lines"`
+	TTL   string `json:"ttl"` // Such as "15m"; DefaultReservationTTL if empty.
+}
+
+// An orderRequest is the body of a POST to /orders: either the lines to
+// order or the reservation to turn into an order.
+type orderRequest struct {
+	Lines       []Line `json:"lines"`
+	Reservation string `json:"reservation"`
+}
+
+// reservations handles "/reservations": POST holds stock for a while.
+func (s *Server) reservations(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var body reservationRequest
+	if err := readJSON(w, req, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	lines, err := MergeLines(body.Lines)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	ttl := DefaultReservationTTL
+	if body.TTL != "" {
+		ttl, err = time.ParseDuration(body.TTL)
+		if err != nil || ttl <= 0 || ttl > MaxReservationTTL {
+			writeError(w, http.StatusBadRequest, "invalid ttl %q; want a duration up to %v", body.TTL, MaxReservationTTL)
+			return
+		}
+	}
+	r, err := s.Store.Reserve(req.Context(), lines, time.Now().Add(ttl))
+	if err != nil {
+		s.storeError(w, req, err)
+		return
+	}
+	w.Header().Set("Location", "/reservations/"+url.PathEscape(r.ID))
+	writeJSON(w, http.StatusCreated, r)
+}
+
+// reservation handles "/reservations/{id}": GET shows a reservation and
+// DELETE releases it.
+func (s *Server) reservation(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, "/reservations/")
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		r, err := s.Store.Reservation(req.Context(), id)
+		if err != nil {
+			s.storeError(w, req, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, r)
+
+	case http.MethodDelete:
+		if err := s.Store.Release(req.Context(), id); err != nil {
+			s.storeError(w, req, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodDelete)
+	}
+}
+
+// orders handles "/orders": POST takes stock out of the inventory for
+// every line of the order, or for none of them if any item is short.
+func (s *Server) orders(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var body orderRequest
+	if err := readJSON(w, req, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	var lines []Line
+	switch {
+	case body.Reservation != "" && len(body.Lines) > 0:
+		writeError(w, http.StatusBadRequest, "give either lines or a reservation, not both")
+		return
+	case body.Reservation == "":
+		var err error
+		if lines, err = MergeLines(body.Lines); err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+	}
+	o, err := s.Store.PlaceOrder(req.Context(), lines, body.Reservation)
+	if err != nil {
+		s.storeError(w, req, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, o)
+}
@@ -0,0 +1,152 @@
+package inventory
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Orders in which a Query can list items. Items with equal prices are in
+// name order; prices sort by currency code first.
+const (
+	SortName  = "name"
+	SortPrice = "price"
+)
+
+const (
+	DefaultPageSize = 100 // Items in a page when the request gives no limit.
+	MaxPageSize     = 1000
+)
+
+// A Query selects a page of items.
+type Query struct {
+	Prefix   string // Only names starting with this.
+	MinPrice *Money // Only prices of at least this, in its currency.
+	MaxPrice *Money // Only prices of at most this, in its currency.
+	Sort     string // SortName or SortPrice.
+	Desc     bool   // Largest first.
+	After    *Item  // Resume after this item; only its name and price matter.
+	Limit    int    // Most items to return; must be positive.
+}
+
+// matches reports whether item passes the query's filters.
+func (q Query) matches(item Item) bool {
+	if !strings.HasPrefix(item.Name, q.Prefix) {
+		return false
+	}
+	if m := q.MinPrice; m != nil && (item.Price.Currency != m.Currency || item.Price.Amount < m.Amount) {
+		return false
+	}
+	if m := q.MaxPrice; m != nil && (item.Price.Currency != m.Currency || item.Price.Amount > m.Amount) {
+		return false
+	}
+	return true
+}
+
+// less reports whether a comes before b in the query's sort order, taken
+// as ascending.
+func (q Query) less(a, b Item) bool {
+	if q.Sort == SortPrice && a.Price != b.Price {
+		if a.Price.Currency != b.Price.Currency {
+			return a.Price.Currency < b.Price.Currency
+		}
+		return a.Price.Amount < b.Price.Amount
+	}
+	return a.Name < b.Name
+}
+
+// A cursor is the opaque token a client passes back to get the next page.
+// It carries the sort it was made for so that it cannot be misapplied.
+type cursor struct {
+	Sort     string `json:"s"`
+	Desc     bool   `json:"d,omitempty"`
+	Name     string `json:"n"`
+	Amount   int64  `json:"a,omitempty"`
+	Currency string `json:"c,omitempty"`
+}
+
+// encodeCursor returns the cursor for the page after item.
+func encodeCursor(q Query, item Item) string {
+	c := cursor{Sort: q.Sort, Desc: q.Desc, Name: item.Name}
+	if q.Sort == SortPrice {
+		c.Amount, c.Currency = item.Price.Amount, item.Price.Currency
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor sets q.After from a cursor made for the same sort.
+func decodeCursor(q *Query, s string) error {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		return errors.New("invalid cursor")
+	}
+	if c.Sort != q.Sort || c.Desc != q.Desc {
+		return errors.New("cursor is for a different sort order")
+	}
+	q.After = &Item{Name: c.Name, Price: Money{c.Amount, c.Currency}}
+	return nil
+}
+
+// parseQuery reads the query parameters of a GET /items request.
+func parseQuery(values url.Values) (Query, error) {
+	q := Query{Prefix: values.Get("q"), Sort: SortName, Limit: DefaultPageSize}
+	if s := values.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxPageSize {
+			return q, fmt.Errorf("invalid limit %q; want 1 to %d", s, MaxPageSize)
+		}
+		q.Limit = n
+	}
+	switch s := values.Get("sort"); s {
+	case "", SortName:
+	case SortPrice:
+		q.Sort = SortPrice
+	default:
+		return q, fmt.Errorf("invalid sort %q; want %s or %s", s, SortName, SortPrice)
+	}
+	switch s := values.Get("order"); s {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, fmt.Errorf("invalid order %q; want asc or desc", s)
+	}
+
+	code := DefaultCurrency
+	if s := values.Get("currency"); s != "" {
+		code = s
+	}
+	for _, p := range []struct {
+		param string
+		dst   **Money
+	}{{"min_price", &q.MinPrice}, {"max_price", &q.MaxPrice}} {
+		s := values.Get(p.param)
+		if s == "" {
+			continue
+		}
+		m, err := ParseMoney(s, code)
+		if err != nil {
+			return q, fmt.Errorf("invalid %s: %v", p.param, err)
+		}
+		*p.dst = &m
+	}
+	if q.MinPrice != nil && q.MaxPrice != nil && q.MinPrice.Amount > q.MaxPrice.Amount {
+		return q, errors.New("min_price is more than max_price")
+	}
+
+	if s := values.Get("cursor"); s != "" {
+		if err := decodeCursor(&q, s); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,378 @@
+package inventory
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Weights of the words in each field of an item when ranking search
+// results.
+const (
+	nameWeight        = 3
+	tagWeight         = 2
+	categoryWeight    = 2
+	descriptionWeight = 1
+)
+
+// How much a search word counts when it matches a word of an item exactly,
+// as the start of the item's word, or with a typo or two.
+const (
+	exactMatch  = 1.0
+	prefixMatch = 0.5
+	fuzzyMatch  = 0.3
+)
+
+// A SearchQuery selects items by the words in them and by facets.
+type SearchQuery struct {
+	Text     string   // Items with any of these words, best matches first; every item if it has none.
+	Category string   // Only items in this category, ignoring case.
+	Tags     []string // Only items with all of these tags.
+	Offset   int      // Matches to skip before the page.
+	Limit    int      // Most matches to return; must be positive.
+}
+
+// A SearchResult is a page of the items matching a search, and counts of
+// all of them by facet.
+type SearchResult struct {
+	Total  int    `json:"total"` // Matches, on every page.
+	Hits   []Hit  `json:"hits"`
+	Facets Facets `json:"facets"`
+}
+
+// A Hit is an item matching a search, with the score it was ranked by.
+type Hit struct {
+	Item  Item    `json:"item"`
+	Score float64 `json:"score"` // Zero when the search had no text.
+}
+
+// Facets count the items matching a search by category, leaving out those
+// with none, and by price bucket.
+type Facets struct {
+	Category []FacetCount `json:"category"`
+	Price    []FacetCount `json:"price"`
+}
+
+// A FacetCount is the number of matches with a value of a facet.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// priceBuckets are the bounds, in major units of each currency, of the
+// buckets of the price facet.
+var priceBuckets = []int64{10, 50, 100, 500}
+
+// PriceBounds returns the bounds of the price buckets in minor units of
+// the currency code.
+func PriceBounds(code string) []int64 {
+	scale := int64(math.Pow10(currencies[code].digits))
+	bounds := make([]int64, len(priceBuckets))
+	for i, b := range priceBuckets {
+		bounds[i] = b * scale
+	}
+	return bounds
+}
+
+// PriceBucket returns the label of the ith price bucket of the currency
+// code, such as "USD 10-50" or "USD 500+".
+func PriceBucket(code string, i int) string {
+	switch {
+	case i == 0:
+		return fmt.Sprintf("%s 0-%d", code, priceBuckets[0])
+	case i == len(priceBuckets):
+		return fmt.Sprintf("%s %d+", code, priceBuckets[i-1])
+	}
+	return fmt.Sprintf("%s %d-%d", code, priceBuckets[i-1], priceBuckets[i])
+}
+
+// A priceKey is a price bucket: its currency and its index.
+type priceKey struct {
+	currency string
+	bucket   int
+}
+
+// priceBucket returns the price bucket holding m.
+func priceBucket(m Money) priceKey {
+	bounds := PriceBounds(m.Currency)
+	return priceKey{m.Currency, sort.Search(len(bounds), func(i int) bool { return m.Amount < bounds[i] })}
+}
+
+// words splits text into lower-case words of letters and digits.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+}
+
+// itemWords returns the words of an item, weighted by the fields they are
+// in.
+func itemWords(item Item) map[string]float64 {
+	weights := map[string]float64{}
+	add := func(text string, weight float64) {
+		for _, w := range words(text) {
+			weights[w] += weight
+		}
+	}
+	add(item.Name, nameWeight)
+	add(item.Category, categoryWeight)
+	add(strings.Join(item.Tags, " "), tagWeight)
+	add(item.Description, descriptionWeight)
+	return weights
+}
+
+// A textIndex is an inverted index of the words in items.
+type textIndex struct {
+	postings map[string]map[string]float64 // Weight of each word in each item, by word and name.
+	vocab    []string                      // The words in postings, sorted for prefix matching.
+}
+
+func newTextIndex() *textIndex {
+	return &textIndex{postings: map[string]map[string]float64{}}
+}
+
+func (x *textIndex) add(item Item) {
+	for w, weight := range itemWords(item) {
+		p := x.postings[w]
+		if p == nil {
+			p = map[string]float64{}
+			x.postings[w] = p
+			i, _ := slices.BinarySearch(x.vocab, w)
+			x.vocab = slices.Insert(x.vocab, i, w)
+		}
+		p[item.Name] = weight
+	}
+}
+
+func (x *textIndex) remove(item Item) {
+	for w := range itemWords(item) {
+		p := x.postings[w]
+		delete(p, item.Name)
+		if len(p) == 0 {
+			delete(x.postings, w)
+			i, _ := slices.BinarySearch(x.vocab, w)
+			x.vocab = slices.Delete(x.vocab, i, i+1)
+		}
+	}
+}
+
+// matches returns the indexed words that match w, with how well they do.
+func (x *textIndex) matches(w string) map[string]float64 {
+	found := map[string]float64{}
+	if _, ok := x.postings[w]; ok {
+		found[w] = exactMatch
+	}
+	if len([]rune(w)) >= 2 {
+		for i, _ := slices.BinarySearch(x.vocab, w); i < len(x.vocab) && strings.HasPrefix(x.vocab[i], w); i++ {
+			if x.vocab[i] != w {
+				found[x.vocab[i]] = prefixMatch
+			}
+		}
+	}
+	if typos := maxTypos(w); typos > 0 {
+		for _, v := range x.vocab {
+			if _, ok := found[v]; !ok && distance(w, v, typos) <= typos {
+				found[v] = fuzzyMatch
+			}
+		}
+	}
+	return found
+}
+
+// scores returns the score of every item, of n in all, that has any of the
+// words in text. For each word, an item scores for its best match, by how
+// good the match is, the weight of the item's word, and how rare it is.
+func (x *textIndex) scores(text string, n int) map[string]float64 {
+	scores := map[string]float64{}
+	for _, w := range words(text) {
+		best := map[string]float64{}
+		for v, quality := range x.matches(w) {
+			p := x.postings[v]
+			rarity := math.Log(1 + float64(n)/float64(len(p)))
+			for name, weight := range p {
+				best[name] = max(best[name], quality*weight*rarity)
+			}
+		}
+		for name, score := range best {
+			scores[name] += score
+		}
+	}
+	return scores
+}
+
+// maxTypos returns the edits a word may need to match: none for short
+// words, one for longer ones and two for long ones.
+func maxTypos(w string) int {
+	switch n := len([]rune(w)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+// distance returns the Levenshtein distance between a and b, or limit+1
+// if it is more than limit.
+func distance(a, b string, limit int) int {
+	s, t := []rune(a), []rune(b)
+	if d := len(s) - len(t); d > limit || -d > limit {
+		return limit + 1
+	}
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		lowest := cur[0]
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			lowest = min(lowest, cur[j])
+		}
+		if lowest > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(t)]
+}
+
+// filters reports whether item passes the category and tag filters of q.
+func (q SearchQuery) filters(item Item) bool {
+	if q.Category != "" && !strings.EqualFold(item.Category, q.Category) {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !slices.Contains(item.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// countFacets counts items by category and price bucket.
+func countFacets(items []Item) Facets {
+	categories, prices := map[string]int{}, map[priceKey]int{}
+	for _, item := range items {
+		if item.Category != "" {
+			categories[item.Category]++
+		}
+		prices[priceBucket(item.Price)]++
+	}
+	return Facets{Category: facetCounts(categories), Price: priceCounts(prices)}
+}
+
+// priceCounts lists counts by price bucket, in order of currency and then
+// of price, so that "USD 100-500" follows "USD 50-100".
+func priceCounts(counts map[priceKey]int) []FacetCount {
+	keys := make([]priceKey, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].currency != keys[j].currency {
+			return keys[i].currency < keys[j].currency
+		}
+		return keys[i].bucket < keys[j].bucket
+	})
+	facets := []FacetCount{}
+	for _, k := range keys {
+		facets = append(facets, FacetCount{PriceBucket(k.currency, k.bucket), counts[k]})
+	}
+	return facets
+}
+
+// facetCounts lists counts by value, in value order.
+func facetCounts(counts map[string]int) []FacetCount {
+	facets := []FacetCount{}
+	for _, v := range sortedKeys(counts) {
+		facets = append(facets, FacetCount{v, counts[v]})
+	}
+	return facets
+}
+
+// textSearch returns the page of items matching q, ranked by score, then
+// by name.
+func (s *state) textSearch(q SearchQuery) SearchResult {
+	// Text with no words, such as punctuation, is no search.
+	hasText := len(words(q.Text)) > 0
+	var scores map[string]float64
+	if hasText {
+		scores = s.index.scores(q.Text, len(s.items))
+	}
+	var matches []Item
+	for _, name := range s.names {
+		item := s.items[name]
+		if _, ok := scores[name]; (ok || !hasText) && q.filters(item) {
+			matches = append(matches, item)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return scores[matches[i].Name] > scores[matches[j].Name] })
+
+	result := SearchResult{Total: len(matches), Hits: []Hit{}, Facets: countFacets(matches)}
+	held := s.reserved()
+	start := min(q.Offset, len(matches))
+	for _, item := range matches[start : start+min(q.Limit, len(matches)-start)] {
+		item.Reserved = held[item.Name]
+		result.Hits = append(result.Hits, Hit{item, scores[item.Name]})
+	}
+	return result
+}
+
+// parseSearch reads the query parameters of a GET /search request.
+func parseSearch(values url.Values) (SearchQuery, error) {
+	q := SearchQuery{Text: strings.TrimSpace(values.Get("q")), Category: strings.TrimSpace(values.Get("category")), Limit: DefaultPageSize}
+	if len(values["tag"]) > 0 {
+		tags, err := normalizeTags(values["tag"])
+		if err != nil {
+			return q, err
+		}
+		q.Tags = tags
+	}
+	if s := values.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxPageSize {
+			return q, fmt.Errorf("invalid limit %q; want 1 to %d", s, MaxPageSize)
+		}
+		q.Limit = n
+	}
+	if s := values.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("invalid offset %q", s)
+		}
+		q.Offset = n
+	}
+	return q, nil
+}
+
+// search handles "/search": GET finds items by the words in them, with q,
+// narrowed to a category and to tags, with category and tag (which may be
+// repeated). It pages through the matches with offset and limit.
+func (s *Server) search(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	q, err := parseSearch(req.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	result, err := s.Store.Search(req.Context(), q)
+	if err != nil {
+		s.storeError(w, req, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,171 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var searchItems = []Item{
+	{Name: "wool-hat", Price: Money{2500, "USD"}, Category: "hats", Tags: []string{"warm", "wool"}, Description: "A knitted hat with a bobble"},
+	{Name: "straw-hat", Price: Money{1500, "USD"}, Category: "hats", Tags: []string{"summer"}},
+	{Name: "socks", Price: Money{500, "USD"}, Category: "footwear", Tags: []string{"wool"}, Description: "Thick wool socks"},
+	{Name: "boots", Price: Money{12000, "USD"}, Category: "footwear", Description: "Waterproof leather boots"},
+	{Name: "umbrella", Price: Money{3000, "EUR"}},
+	{Name: "tent", Price: Money{8000, "USD"}},
+	{Name: "kayak", Price: Money{60000, "USD"}},
+}
+
+// names returns the names of the items hit, in order.
+func names(r SearchResult) string {
+	var names []string
+	for _, h := range r.Hits {
+		names = append(names, h.Item.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+// testSearch checks the search of a store holding searchItems.
+func testSearch(t *testing.T, s InventoryStore) {
+	ctx := context.Background()
+	tests := []struct {
+		q     SearchQuery
+		want  string
+		total int
+	}{
+		{SearchQuery{Text: "wool"}, "wool-hat socks", 2},
+		{SearchQuery{Text: "HAT"}, "wool-hat straw-hat", 2},             // Also in the description of wool-hat.
+		{SearchQuery{Text: "boo"}, "boots", 1},                          // Prefix.
+		{SearchQuery{Text: "umbrela"}, "umbrella", 1},                   // Typo.
+		{SearchQuery{Text: "waterprof lether"}, "boots", 1},             // Typos in two words.
+		{SearchQuery{Text: "hat socks"}, "socks wool-hat straw-hat", 3}, // Any word.
+		{SearchQuery{Text: "wool", Category: "Footwear"}, "socks", 1},
+		{SearchQuery{Tags: []string{"wool"}}, "socks wool-hat", 2},
+		{SearchQuery{Tags: []string{"wool", "warm"}}, "wool-hat", 1},
+		{SearchQuery{Text: "cat"}, "", 0},
+		{SearchQuery{Text: "--", Category: "hats"}, "straw-hat wool-hat", 2}, // No words.
+		{SearchQuery{Offset: 1, Limit: 2}, "kayak socks", 7},
+		{SearchQuery{Offset: 10}, "", 7},
+	}
+	for _, tt := range tests {
+		if tt.q.Limit == 0 {
+			tt.q.Limit = 10
+		}
+		r, err := s.Search(ctx, tt.q)
+		if err != nil {
+			t.Fatalf("Search(%+v): %v", tt.q, err)
+		}
+		if got := names(r); got != tt.want || r.Total != tt.total {
+			t.Errorf("Search(%+v) = %q of %d, want %q of %d", tt.q, got, r.Total, tt.want, tt.total)
+		}
+	}
+
+	r, err := s.Search(ctx, SearchQuery{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Prices are in order of bucket, not of label.
+	want := Facets{
+		Category: []FacetCount{{"footwear", 2}, {"hats", 2}},
+		Price:    []FacetCount{{"EUR 10-50", 1}, {"USD 0-10", 1}, {"USD 10-50", 2}, {"USD 50-100", 1}, {"USD 100-500", 1}, {"USD 500+", 1}},
+	}
+	if !reflect.DeepEqual(r.Facets, want) {
+		t.Errorf("facets = %+v, want %+v", r.Facets, want)
+	}
+
+	// The index follows changes.
+	hat, _ := s.Get(ctx, "wool-hat")
+	hat.Description = "A knitted hat with a pompom"
+	if err := s.Update(ctx, hat); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "socks", 0); err != nil {
+		t.Fatal(err)
+	}
+	for text, want := range map[string]string{"bobble": "", "pompom": "wool-hat", "wool": "wool-hat"} {
+		if r, err := s.Search(ctx, SearchQuery{Text: text, Limit: 10}); err != nil || names(r) != want {
+			t.Errorf("after changes, Search(%q) = %q, %v; want %q", text, names(r), err, want)
+		}
+	}
+}
+
+func TestMemoryStoreSearch(t *testing.T) {
+	t.Parallel()
+	testSearch(t, NewMemoryStore(searchItems...))
+}
+
+func TestFileStoreSearch(t *testing.T) {
+	t.Parallel()
+	s := newFileStore(t)
+	for _, item := range searchItems {
+		if err := s.Create(context.Background(), item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testSearch(t, reopen(t, s))
+}
+
+func TestDistance(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"boots", "boots", 1, 0},
+		{"boots", "bots", 1, 1},
+		{"umbrela", "umbrella", 1, 1},
+		{"hat", "cat", 1, 1},
+		{"waterproof", "waterprof", 2, 1},
+		{"kitten", "sitting", 3, 3},
+		{"kitten", "sitting", 2, 3},
+		{"hat", "umbrella", 2, 3},
+		{"café", "cafe", 1, 1},
+	}
+	for _, tt := range tests {
+		if got := distance(tt.a, tt.b, tt.limit); got != tt.want {
+			t.Errorf("distance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestSearchAPI(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer((&Server{Store: NewMemoryStore(searchItems...)}).Handler())
+	t.Cleanup(ts.Close)
+
+	resp, body := do(t, ts, "GET", "/search?q=wool&tag=Wool&limit=1", "")
+	var r SearchResult
+	if err := json.Unmarshal([]byte(body), &r); err != nil || resp.StatusCode != 200 {
+		t.Fatalf("GET /search = %d %s", resp.StatusCode, body)
+	}
+	if names(r) != "wool-hat" || r.Total != 2 || r.Hits[0].Score <= 0 || len(r.Facets.Category) != 2 {
+		t.Errorf("GET /search = %s", body)
+	}
+
+	// Descriptive fields are set like any other.
+	resp, body = do(t, ts, "POST", "/items", `{"name":"scarf","price":20,"category":" neckwear ","tags":["Wool","warm","wool"],"description":"Long"}`)
+	if want := `{"name":"scarf","price":{"amount":"20.00","currency":"USD"},"quantity":0,"reserved":0,"description":"Long","category":"neckwear","tags":["warm","wool"],"version":1}`; resp.StatusCode != 201 || strings.TrimSpace(body) != want {
+		t.Errorf("POST /items = %d %s, want %s", resp.StatusCode, body, want)
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "/search?q=scarf&category=neckwear", "", 200},
+		{"GET", "/search?limit=0", "", 400},
+		{"GET", "/search?offset=-1", "", 400},
+		{"GET", "/search?tag=not%20a%20tag", "", 400},
+		{"POST", "/search", "", 405},
+		{"PATCH", "/items/scarf", `{"tags":["x y"]}`, 400},
+		{"PATCH", "/items/scarf", `{"category":"` + strings.Repeat("c", maxCategorySize+1) + `"}`, 400},
+	} {
+		if resp, body := do(t, ts, tt.method, tt.path, tt.body); resp.StatusCode != tt.status {
+			t.Errorf("%s %s = %d %s, want %d", tt.method, tt.path, resp.StatusCode, body, tt.status)
+		}
+	}
+}
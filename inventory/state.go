@@ -0,0 +1,324 @@
+package inventory
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A change is the effect of one store operation. The memory and file
+// stores work out a change under their lock, then apply it all at once;
+// the file store logs it first.
+type change struct {
+	Put     []Item        `json:"put,omitempty"`
+	Delete  []string      `json:"delete,omitempty"`
+	Reserve []Reservation `json:"reserve,omitempty"`
+	Release []string      `json:"release,omitempty"`
+	Events  []Event       `json:"events,omitempty"`
+}
+
+// state is the items and reservations kept by the memory and file stores.
+// Expired reservations are ignored wherever stock is counted and dropped
+// whenever a change is applied.
+type state struct {
+	items        map[string]Item // Reserved is always zero here.
+	reservations map[string]Reservation
+	history      map[string][]Event
+	seq          int64 // Of the last event applied.
+	watchers     map[*func(Event)]bool
+	now          func() time.Time
+
+	// Indexes of items, kept in step with items by put and remove.
+	names   []string // Sorted.
+	byPrice []Item   // Names and prices only, in priceOrder.
+	index   *textIndex
+}
+
+// priceOrder sorts the byPrice index.
+var priceOrder = Query{Sort: SortPrice}
+
+// put adds or replaces an item.
+func (s *state) put(item Item) {
+	old, ok := s.items[item.Name]
+	if ok {
+		s.byPrice = slices.Delete(s.byPrice, s.search(old), s.search(old)+1)
+		s.index.remove(old)
+	} else {
+		i, _ := slices.BinarySearch(s.names, item.Name)
+		s.names = slices.Insert(s.names, i, item.Name)
+	}
+	s.items[item.Name] = item
+	key := Item{Name: item.Name, Price: item.Price}
+	s.byPrice = slices.Insert(s.byPrice, s.search(key), key)
+	s.index.add(item)
+}
+
+// remove deletes an item.
+func (s *state) remove(name string) {
+	old, ok := s.items[name]
+	if !ok {
+		return
+	}
+	i, _ := slices.BinarySearch(s.names, name)
+	s.names = slices.Delete(s.names, i, i+1)
+	s.byPrice = slices.Delete(s.byPrice, s.search(old), s.search(old)+1)
+	s.index.remove(old)
+	delete(s.items, name)
+}
+
+// search returns the index in byPrice at which item is or would be.
+func (s *state) search(item Item) int {
+	return sort.Search(len(s.byPrice), func(i int) bool { return !priceOrder.less(s.byPrice[i], item) })
+}
+
+func newState() *state {
+	return &state{items: map[string]Item{}, reservations: map[string]Reservation{}, history: map[string][]Event{}, watchers: map[*func(Event)]bool{}, now: time.Now, index: newTextIndex()}
+}
+
+// reserved returns the quantity of each item held by unexpired reservations.
+func (s *state) reserved() map[string]int64 {
+	now := s.now()
+	held := map[string]int64{}
+	for _, r := range s.reservations {
+		if r.Expires.After(now) {
+			for _, l := range r.Lines {
+				held[l.Item] += l.Quantity
+			}
+		}
+	}
+	return held
+}
+
+func (s *state) get(name string) (Item, error) {
+	item, ok := s.items[name]
+	if !ok {
+		return Item{}, notFound(name)
+	}
+	item.Reserved = s.reserved()[name]
+	return item, nil
+}
+
+func (s *state) list() []Item {
+	held := s.reserved()
+	items := make([]Item, len(s.names))
+	for i, name := range s.names {
+		items[i] = s.items[name]
+		items[i].Reserved = held[name]
+	}
+	return items
+}
+
+// query returns the items selected by q. It narrows the range to scan with
+// the index for q's sort order, then filters what is left.
+func (s *state) query(q Query) []Item {
+	n := len(s.names)
+	key := func(i int) Item { return Item{Name: s.names[i]} }
+	if q.Sort == SortPrice {
+		key = func(i int) Item { return s.byPrice[i] }
+	}
+	first := func(f func(Item) bool) int { return sort.Search(n, func(i int) bool { return f(key(i)) }) }
+
+	lo, hi := 0, n
+	switch {
+	case q.Sort == SortName && q.Prefix != "":
+		lo = first(func(k Item) bool { return k.Name >= q.Prefix })
+		hi = first(func(k Item) bool { return k.Name > q.Prefix && !strings.HasPrefix(k.Name, q.Prefix) })
+	case q.Sort == SortPrice && (q.MinPrice != nil || q.MaxPrice != nil):
+		low, high := q.MinPrice, q.MaxPrice
+		if low == nil {
+			low = &Money{0, high.Currency}
+		}
+		lo = first(func(k Item) bool {
+			return k.Price.Currency > low.Currency || k.Price.Currency == low.Currency && k.Price.Amount >= low.Amount
+		})
+		hi = first(func(k Item) bool {
+			return k.Price.Currency > low.Currency || high != nil && k.Price.Currency == high.Currency && k.Price.Amount > high.Amount
+		})
+	}
+	if q.After != nil {
+		if q.Desc {
+			hi = min(hi, first(func(k Item) bool { return !q.less(k, *q.After) }))
+		} else {
+			lo = max(lo, first(func(k Item) bool { return q.less(*q.After, k) }))
+		}
+	}
+
+	held := s.reserved()
+	var items []Item
+	for i := 0; i < hi-lo && len(items) < q.Limit; i++ {
+		j := lo + i
+		if q.Desc {
+			j = hi - 1 - i
+		}
+		item := s.items[key(j).Name]
+		if q.matches(item) {
+			item.Reserved = held[item.Name]
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (s *state) create(item Item) (change, error) {
+	if _, ok := s.items[item.Name]; ok {
+		return change{}, conflict(item.Name)
+	}
+	item.Reserved = 0
+	item.Version = 1
+	return change{Put: []Item{item}, Events: []Event{{Item: item.Name, Op: OpCreate, New: &item}}}, nil
+}
+
+// update replaces an item, refusing to leave less on hand than is reserved.
+func (s *state) update(item Item) (change, error) {
+	current, ok := s.items[item.Name]
+	if !ok {
+		return change{}, notFound(item.Name)
+	}
+	if item.Version != 0 && item.Version != current.Version {
+		return change{}, mismatch(item.Name, item.Version, current.Version)
+	}
+	if held := s.reserved()[item.Name]; item.Quantity < held {
+		return change{}, insufficient(item.Name, held, item.Quantity)
+	}
+	item.Reserved = 0
+	item.Version = current.Version + 1
+	return change{Put: []Item{item}, Events: []Event{{Item: item.Name, Op: OpUpdate, Old: &current, New: &item}}}, nil
+}
+
+func (s *state) delete(name string, version int64) (change, error) {
+	current, ok := s.items[name]
+	if !ok {
+		return change{}, notFound(name)
+	}
+	if version != 0 && version != current.Version {
+		return change{}, mismatch(name, version, current.Version)
+	}
+	return change{Delete: []string{name}, Events: []Event{{Item: name, Op: OpDelete, Old: &current}}}, nil
+}
+
+// record adds e to the history unless it is already there, as happens
+// when a file store replays changes its snapshot holds.
+func (s *state) record(e Event) {
+	if e.Seq <= s.seq {
+		return
+	}
+	s.history[e.Item] = append(s.history[e.Item], e)
+	s.seq = e.Seq
+	for fn := range s.watchers {
+		(*fn)(e)
+	}
+}
+
+// events returns the history of the named item.
+func (s *state) events(name string) ([]Event, error) {
+	events := s.history[name]
+	if len(events) == 0 {
+		return nil, notFound(name)
+	}
+	return slices.Clone(events), nil
+}
+
+// available checks that every line can be taken from the stock not
+// already reserved.
+func (s *state) available(lines []Line) error {
+	held := s.reserved()
+	for _, l := range lines {
+		item, ok := s.items[l.Item]
+		if !ok {
+			return notFound(l.Item)
+		}
+		if have := item.Quantity - held[l.Item]; have < l.Quantity {
+			return insufficient(l.Item, l.Quantity, have)
+		}
+	}
+	return nil
+}
+
+func (s *state) reserve(lines []Line, expires time.Time) (Reservation, change, error) {
+	if err := s.available(lines); err != nil {
+		return Reservation{}, change{}, err
+	}
+	r := Reservation{ID: newID(), Lines: lines, Expires: expires.UTC()}
+	return r, change{Reserve: []Reservation{r}}, nil
+}
+
+// reservation returns the unexpired reservation id.
+func (s *state) reservation(id string) (Reservation, error) {
+	r, ok := s.reservations[id]
+	if !ok || !r.Expires.After(s.now()) {
+		return Reservation{}, noReservation(id)
+	}
+	return r, nil
+}
+
+func (s *state) release(id string) (change, error) {
+	if _, err := s.reservation(id); err != nil {
+		return change{}, err
+	}
+	return change{Release: []string{id}}, nil
+}
+
+// order takes lines out of stock, or the lines of reservation id if it is
+// not empty, failing as a whole if any item is short.
+func (s *state) order(lines []Line, id string) (Order, change, error) {
+	var c change
+	if id != "" {
+		r, err := s.reservation(id)
+		if err != nil {
+			return Order{}, change{}, err
+		}
+		lines = r.Lines
+		c.Release = []string{id}
+		// The reserved stock is already set aside, but the item may have
+		// been deleted since, or deleted and created again with less.
+		for _, l := range lines {
+			item, ok := s.items[l.Item]
+			if !ok {
+				return Order{}, change{}, notFound(l.Item)
+			}
+			if item.Quantity < l.Quantity {
+				return Order{}, change{}, insufficient(l.Item, l.Quantity, item.Quantity)
+			}
+		}
+	} else if err := s.available(lines); err != nil {
+		return Order{}, change{}, err
+	}
+
+	o := Order{ID: newID(), Placed: s.now().UTC()}
+	for _, l := range lines {
+		old := s.items[l.Item]
+		item := old
+		item.Quantity -= l.Quantity
+		item.Version++
+		c.Put = append(c.Put, item)
+		c.Events = append(c.Events, Event{Item: item.Name, Op: OpOrder, Old: &old, New: &item})
+		o.Lines = append(o.Lines, OrderLine{Line: l, Price: item.Price})
+	}
+	return o, c, nil
+}
+
+// apply makes a change, dropping any reservations that have expired.
+func (s *state) apply(c change) {
+	for _, item := range c.Put {
+		s.put(item)
+	}
+	for _, name := range c.Delete {
+		s.remove(name)
+	}
+	for _, r := range c.Reserve {
+		s.reservations[r.ID] = r
+	}
+	for _, id := range c.Release {
+		delete(s.reservations, id)
+	}
+	for _, e := range c.Events {
+		s.record(e)
+	}
+	now := s.now()
+	for id, r := range s.reservations {
+		if !r.Expires.After(now) {
+			delete(s.reservations, id)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testStore checks the behaviour every InventoryStore must share. The
+// store must start empty.
+func testStore(t *testing.T, s InventoryStore) {
+	t.Helper()
+	ctx := context.Background()
+	shoes := Item{Name: "shoes", Price: Money{5000, "USD"}, Version: 1}
+	socks := Item{Name: "socks", Price: Money{500, "USD"}, Version: 1}
+
+	if err := s.Create(ctx, socks); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(ctx, shoes); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(ctx, shoes); !errors.Is(err, ErrConflict) {
+		t.Errorf("creating a duplicate: got %v, want ErrConflict", err)
+	}
+	items, err := s.List(ctx)
+	if err != nil || !reflect.DeepEqual(items, []Item{shoes, socks}) {
+		t.Errorf("List = %v, %v; want %v", items, err, []Item{shoes, socks})
+	}
+
+	shoes.Price = Money{4500, "USD"}
+	if err := s.Update(ctx, shoes); err != nil {
+		t.Fatal(err)
+	}
+	shoes.Version = 2
+	if got, err := s.Get(ctx, "shoes"); err != nil || !reflect.DeepEqual(got, shoes) {
+		t.Errorf("Get = %v, %v; want %v", got, err, shoes)
+	}
+	stale := shoes
+	stale.Version = 1
+	if err := s.Update(ctx, stale); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("updating a stale version: got %v, want ErrVersionMismatch", err)
+	}
+	shoes.Version = 0 // Unconditional.
+	if err := s.Update(ctx, shoes); err != nil {
+		t.Fatal(err)
+	}
+	shoes.Version = 3
+	if err := s.Update(ctx, Item{Name: "hats"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("updating a missing item: got %v, want ErrNotFound", err)
+	}
+
+	if err := s.Delete(ctx, "socks", 2); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("deleting a stale version: got %v, want ErrVersionMismatch", err)
+	}
+	if err := s.Delete(ctx, "socks", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "socks", 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting a missing item: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.Get(ctx, "socks"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("getting a deleted item: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+	testStore(t, NewMemoryStore())
+}
+
+func TestSeed(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	s := NewMemoryStore(Item{Name: "shoes", Price: Money{100, "USD"}})
+	if err := Seed(ctx, s, DefaultItems...); err != nil {
+		t.Fatal(err)
+	}
+	items, _ := s.List(ctx)
+	socks := DefaultItems[1]
+	socks.Version = 1
+	want := []Item{{Name: "shoes", Price: Money{100, "USD"}, Version: 1}, socks}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("after seeding got %v, want %v", items, want)
+	}
+}
+
+// testQuery checks Query against filtering and sorting every item. The
+// store must start empty.
+func testQuery(t *testing.T, s InventoryStore) {
+	t.Helper()
+	ctx := context.Background()
+	var all []Item
+	for i, name := range []string{"a", "ab", "abc", "b", "ba", "bb", "c", "ca", "d"} {
+		item := Item{Name: name, Price: Money{int64(i % 4 * 100), "USD"}}
+		if i%3 == 0 {
+			item.Price.Currency = "EUR"
+		}
+		if err := s.Create(ctx, item); err != nil {
+			t.Fatal(err)
+		}
+		item.Version = 1
+		all = append(all, item)
+	}
+
+	usd := func(amount int64) *Money { return &Money{amount, "USD"} }
+	queries := []Query{
+		{},
+		{Prefix: "a"},
+		{Prefix: "b", Sort: SortPrice},
+		{Prefix: "ca"},
+		{Prefix: "e"},
+		{Sort: SortPrice},
+		{MinPrice: usd(100)},
+		{MaxPrice: usd(200), Sort: SortPrice},
+		{MinPrice: usd(100), MaxPrice: usd(200), Sort: SortPrice},
+		{MinPrice: &Money{0, "EUR"}, Sort: SortPrice},
+		{MinPrice: usd(500)},
+	}
+	for _, q := range queries {
+		for _, desc := range []bool{false, true} {
+			q := q
+			q.Desc = desc
+			if q.Sort == "" {
+				q.Sort = SortName
+			}
+
+			var want []Item
+			for _, item := range all {
+				if q.matches(item) {
+					want = append(want, item)
+				}
+			}
+			sort.SliceStable(want, func(i, j int) bool {
+				if q.Desc {
+					return q.less(want[j], want[i])
+				}
+				return q.less(want[i], want[j])
+			})
+
+			// Page through, two items at a time.
+			var got []Item
+			q.Limit = 2
+			for {
+				page, err := s.Query(ctx, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, page...)
+				if len(page) < q.Limit || len(got) > len(all) {
+					break
+				}
+				q.After = &page[len(page)-1]
+			}
+			q.After = nil
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%+v: got %v, want %v", q, got, want)
+			}
+		}
+	}
+}
+
+func TestMemoryStoreQuery(t *testing.T) {
+	t.Parallel()
+	testQuery(t, NewMemoryStore())
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// stats holds the counters the broadcaster updates as it runs.
+type stats struct {
+	messages atomic.Int64 // messages broadcast since startup
+	dropped  atomic.Int64 // messages discarded because a client fell behind
+	rate     atomic.Int64 // messages broadcast during the last second
+
+	archiveErrors atomic.Int64 // messages that could not be written to the chat log
+	webhookErrors atomic.Int64 // messages that could not be posted to an outgoing webhook
+}
+
+// A session describes one connected client.
+type session struct {
+	Name        string    `json:"name"`
+	Addr        string    `json:"addr"`
+	Room        string    `json:"room"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// A report is a copy of the broadcaster's state taken for the admin listener.
+type report struct {
+	sessions []session
+	clients  map[string]int   // connected clients per room
+	messages map[string]int64 // messages broadcast per room
+}
+
+// newReport copies the broadcaster's state so it can be read outside its goroutine.
+func newReport(clients map[client]bool, roomMessages map[string]int64) report {
+	r := report{clients: map[string]int{}, messages: map[string]int64{}}
+	for cli := range clients {
+		r.sessions = append(r.sessions, session{Name: cli.name, Addr: cli.addr, Room: cli.room, ConnectedAt: cli.since})
+		r.clients[cli.room]++
+	}
+	for room, n := range roomMessages {
+		r.messages[room] = n
+	}
+	sort.Slice(r.sessions, func(i, j int) bool { return r.sessions[i].ConnectedAt.Before(r.sessions[j].ConnectedAt) })
+	return r
+}
+
+// report asks the broadcaster for a snapshot of its state. After Close it
+// returns an empty report.
+func (s *Server) report() report {
+	reply := make(chan report, 1)
+	select {
+	case s.reports <- reply:
+		return <-reply
+	case <-s.done:
+		return report{}
+	}
+}
+
+// AdminHandler returns the HTTP handler exposing metrics, the session list,
+// the chat log and the incoming webhook.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.metrics)
+	mux.HandleFunc("/clients", s.clients)
+	mux.HandleFunc("/messages", s.messageLog)
+	mux.HandleFunc("/webhook", s.incomingWebhook)
+	return mux
+}
+
+// metrics writes the chat statistics in the Prometheus text exposition format.
+func (s *Server) metrics(w http.ResponseWriter, req *http.Request) {
+	r := s.report()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	metric(w, "chat_connected_clients", "gauge", "Number of clients currently connected.")
+	fmt.Fprintf(w, "chat_connected_clients %d\n", len(r.sessions))
+	metric(w, "chat_messages_total", "counter", "Messages broadcast since startup.")
+	fmt.Fprintf(w, "chat_messages_total %d\n", s.stats.messages.Load())
+	metric(w, "chat_messages_per_second", "gauge", "Messages broadcast during the last second.")
+	fmt.Fprintf(w, "chat_messages_per_second %d\n", s.stats.rate.Load())
+	metric(w, "chat_dropped_messages_total", "counter", "Messages dropped because a client's queue was full.")
+	fmt.Fprintf(w, "chat_dropped_messages_total %d\n", s.stats.dropped.Load())
+	metric(w, "chat_broadcast_queue_depth", "gauge", "Messages waiting for the broadcaster.")
+	fmt.Fprintf(w, "chat_broadcast_queue_depth %d\n", len(s.messages))
+	metric(w, "chat_archive_queue_depth", "gauge", "Messages waiting to be written to the chat log.")
+	fmt.Fprintf(w, "chat_archive_queue_depth %d\n", len(s.archive))
+	metric(w, "chat_archive_errors_total", "counter", "Messages that could not be written to the chat log.")
+	fmt.Fprintf(w, "chat_archive_errors_total %d\n", s.stats.archiveErrors.Load())
+	metric(w, "chat_webhook_queue_depth", "gauge", "Messages waiting to be posted to outgoing webhooks.")
+	fmt.Fprintf(w, "chat_webhook_queue_depth %d\n", len(s.hooks))
+	metric(w, "chat_webhook_errors_total", "counter", "Messages that could not be posted to an outgoing webhook.")
+	fmt.Fprintf(w, "chat_webhook_errors_total %d\n", s.stats.webhookErrors.Load())
+
+	metric(w, "chat_room_clients", "gauge", "Number of clients currently in each room.")
+	for _, room := range sortedKeys(r.clients) {
+		fmt.Fprintf(w, "chat_room_clients{room=\"%s\"} %d\n", labelValue(room), r.clients[room])
+	}
+	metric(w, "chat_room_messages_total", "counter", "Messages broadcast in each room since startup.")
+	for _, room := range sortedKeys(r.messages) {
+		fmt.Fprintf(w, "chat_room_messages_total{room=\"%s\"} %d\n", labelValue(room), r.messages[room])
+	}
+}
+
+// clients lists the current sessions as JSON.
+func (s *Server) clients(w http.ResponseWriter, req *http.Request) {
+	r := s.report()
+	if r.sessions == nil {
+		r.sessions = []session{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(r.sessions); err != nil {
+		log.Println("encoding sessions:", err)
+	}
+}
+
+// messageLog answers queries over the chat log. It accepts the parameters
+// since and until (RFC 3339 times), user, room, q (space-separated terms)
+// and limit.
+func (s *Server) messageLog(w http.ResponseWriter, req *http.Request) {
+	if s.Log == nil {
+		http.Error(w, "the chat log is disabled", http.StatusNotFound)
+		return
+	}
+	params := req.URL.Query()
+	q := Query{Room: params.Get("room"), From: params.Get("user"), Terms: strings.Fields(params.Get("q"))}
+	var err error
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		if v := params.Get(p.name); v != "" {
+			if *p.t, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s: %q", p.name, v), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit: %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	found, err := s.Log.Search(req.Context(), q)
+	if err != nil {
+		http.Error(w, "searching the chat log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if found == nil {
+		found = []Record{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(found); err != nil {
+		log.Println("encoding messages:", err)
+	}
+}
+
+// metric writes the HELP and TYPE lines that precede a metric family.
+func metric(w http.ResponseWriter, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labelEscaper escapes label values as the Prometheus text format
+// requires, leaving other characters, unlike %q, as they are.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue returns v escaped for use between the quotes of a label.
+func labelValue(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	archiveBuffer = 1024 // Records queued for the archiver before new ones are dropped.
+	searchLimit   = 20   // Most records returned by /search.
+)
+
+// A Record is a broadcast message as kept in the chat log.
+type Record struct {
+	Time time.Time `json:"time" bson:"time"`
+	Room string    `json:"room" bson:"room"`
+	From string    `json:"from,omitempty" bson:"from,omitempty"` // Empty for server notices.
+	Text string    `json:"text" bson:"text"`
+}
+
+// A Query selects records from a chat log. Zero fields match everything.
+type Query struct {
+	Since, Until time.Time // Half-open time range [Since, Until).
+	Room         string
+	From         string
+	Terms        []string // Words that must all appear in the text, ignoring case.
+	Limit        int      // Most recent records to return; 0 for all.
+}
+
+// A Log persists broadcast messages and answers queries over them.
+type Log interface {
+	Append(ctx context.Context, r Record) error
+	// Search returns the records matching q in chronological order.
+	Search(ctx context.Context, q Query) ([]Record, error)
+	Close() error
+}
+
+// match reports whether r satisfies q.
+func (q Query) match(r Record) bool {
+	if !q.Since.IsZero() && r.Time.Before(q.Since) || !q.Until.IsZero() && !r.Time.Before(q.Until) {
+		return false
+	}
+	if q.Room != "" && r.Room != q.Room || q.From != "" && r.From != q.From {
+		return false
+	}
+	text := strings.ToLower(r.Text)
+	for _, term := range q.Terms {
+		if !strings.Contains(text, strings.ToLower(term)) {
+			return false
+		}
+	}
+	return true
+}
+
+// A fileLog keeps records as JSON lines in an append-only file.
+type fileLog struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// openFileLog opens the log at path, creating it if necessary.
+func openFileLog(path string) (*fileLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLog{path: path, f: f}, nil
+}
+
+func (l *fileLog) Append(ctx context.Context, r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.f.Write(append(line, '\n'))
+	return err
+}
+
+// Search scans the whole file, keeping the most recent matches.
+func (l *fileLog) Search(ctx context.Context, q Query) ([]Record, error) {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var found []Record
+	// Lines have no length limit: escaping can make a record's line several
+	// times longer than its message.
+	lines := bufio.NewReader(f)
+	for {
+		line, err := lines.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		var r Record
+		if json.Unmarshal(line, &r) == nil && q.match(r) {
+			found = append(found, r)
+			if q.Limit > 0 && len(found) > q.Limit {
+				found = found[1:]
+			}
+		} // Otherwise a torn final line from a crash, or the end; skip it.
+		if err == io.EOF {
+			return found, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (l *fileLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// archiver writes broadcast messages to the log in the background so that
+// a slow log never holds up the broadcaster. Once the server is closed it
+// writes those still queued, then closes s.archived.
+func (s *Server) archiver() {
+	defer close(s.archived)
+	for {
+		select {
+		case r := <-s.archive:
+			s.store(r)
+		case <-s.done:
+			for {
+				select {
+				case r := <-s.archive:
+					s.store(r)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Server) store(r Record) {
+	if err := s.Log.Append(context.Background(), r); err != nil {
+		s.stats.archiveErrors.Add(1)
+		log.Println("archiving message:", err)
+	}
+}
+
+// record queues a broadcast message for the archiver and the outgoing webhooks.
+func (s *Server) record(msg message) {
+	r := Record{Time: time.Now().UTC(), Room: msg.room, From: msg.from, Text: msg.text}
+	s.hook(msg, r)
+	if s.Log == nil {
+		return
+	}
+	select {
+	case s.archive <- r:
+	default:
+		s.stats.archiveErrors.Add(1)
+	}
+}
+
+// search handles "/search <terms>", listing recent messages in the
+// client's room that contain every term.
+func (s *Server) search(cli client, terms []string) {
+	if s.Log == nil {
+		s.reply(cli, "* the chat log is disabled")
+		return
+	}
+	if len(terms) == 0 {
+		s.reply(cli, "* usage: /search <terms>")
+		return
+	}
+	found, err := s.Log.Search(context.Background(), Query{Room: cli.room, Terms: terms, Limit: searchLimit})
+	if err != nil {
+		s.reply(cli, "* search failed: "+err.Error())
+		return
+	}
+	s.reply(cli, "* search: "+pluralize(len(found), "message"))
+	for _, r := range found {
+		line := r.Text
+		if r.From != "" {
+			line = r.From + ": " + r.Text
+		}
+		s.reply(cli, "* "+r.Time.Local().Format(time.DateTime)+" "+line)
+	}
+}
+
+func pluralize(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return strconv.Itoa(n) + " " + noun + "s"
+}
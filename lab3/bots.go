@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VahidBabaey/CloudComputing/lab6/weather"
+)
+
+const botTimeout = 15 * time.Second // How long a bot may take to answer.
+
+// A Bot answers a chat command in-process. Its replies are broadcast to the
+// room the command was sent from under the bot's name.
+type Bot interface {
+	Name() string
+	// Answer returns the reply to the command's arguments.
+	Answer(ctx context.Context, args []string) (string, error)
+}
+
+// ask broadcasts a command addressed to bot and, once the bot has answered
+// in the background, its reply.
+func (s *Server) ask(cli client, bot Bot, line string, args []string) {
+	if !s.post(message{room: cli.room, from: cli.name, text: line}) {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), botTimeout)
+		defer cancel()
+		reply, err := bot.Answer(ctx, args)
+		if err != nil {
+			reply = "sorry, " + err.Error()
+		}
+		for _, line := range strings.Split(reply, "\n") {
+			s.post(message{room: cli.room, from: bot.Name(), text: line})
+		}
+	}()
+}
+
+// A weatherBot answers "/weather <location>" using the OpenWeatherMap client
+// from lab6.
+type weatherBot struct {
+	client *weather.Client
+}
+
+func (weatherBot) Name() string { return "weather" }
+
+func (b weatherBot) Answer(ctx context.Context, args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("usage: /weather <location>")
+	}
+	location := strings.Join(args, " ")
+	type result struct {
+		c   weather.Conditions
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, err := b.client.GetWeather(location)
+		done <- result{c, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return "", r.err
+		}
+		return fmt.Sprintf("%s: %s %.1fºF, humidity %d%%, wind %.2fm/s",
+			location, r.c.Summary, r.c.Temperature.Fahrenheit(), r.c.Humidity, r.c.WindSpeed), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -8,94 +8,351 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+const (
+	lobby        = "lobby" // Room every client joins on arrival.
+	clientBuffer = 32      // Messages queued per client before new ones are dropped.
+	queueSize    = 128     // Messages queued for the broadcaster.
+)
+
+// ErrServerClosed is returned by Serve and ServeTransfers after Close.
+var ErrServerClosed = errors.New("chat: server closed")
 
 type client struct {
 	channel chan<- string // Channel to chat between clients.
 	name    string        // Client's Nmae
+	addr    string        // Remote address of the connection.
+	room    string        // Room the client is chatting in.
+	since   time.Time     // When the client connected.
 }
 
-var (
-	entering = make(chan client)    
-	leaving  = make(chan client)    
-	messages = make(chan string)    // all incoming client messages
-)
+// A message is a line of chat delivered to every client in a room, or a
+// notice delivered to a single client when to is set.
+type message struct {
+	room      string
+	from      string // Empty for server notices.
+	text      string
+	to        string      // Name of the only client to receive the message.
+	delivered chan<- bool // Told whether a client called to was found.
+	hooked    bool        // Injected by an incoming webhook.
+}
+
+func (m message) String() string {
+	if m.from == "" {
+		return "* " + m.text // mark server notices so clients can render them apart
+	}
+	return m.from + ": " + m.text
+}
+
+// A Server relays lines between the clients connected to it. A single
+// broadcaster goroutine owns the set of clients; connection goroutines talk
+// to it over channels.
+type Server struct {
+	ClientBuffer int    // Messages queued per client before new ones are dropped.
+	TransferAddr string // Address announced for file transfers; transfers are disabled if empty.
+	MaxFileSize  int64  // Largest file, in bytes, that may be sent between clients.
+	Log          Log    // Where broadcast messages are kept; nil disables the chat log.
 
-func main() {
-	
-	listener, err := net.Listen("tcp", "localhost:8000")
-	if err != nil {
-		log.Fatal(err) 
+	Bots          map[string]Bot      // In-process bots by command name, without the "/".
+	Webhooks      map[string][]string // URLs each room's messages are posted to.
+	WebhookSecret string              // Secret required by the incoming webhook; disabled if empty.
+
+	entering chan client
+	leaving  chan client
+	messages chan message     // all incoming client messages
+	reports  chan chan report // admin requests for the broadcaster's state
+	archive  chan Record      // broadcast messages waiting to be logged
+	archived chan struct{}    // closed once the archiver has logged those queued at Close
+	hooks    chan Record      // broadcast messages waiting for outgoing webhooks
+	done     chan struct{}    // closed by Close
+	stats    stats
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+
+	transfers transfers
+}
+
+// NewServer returns a server with its broadcaster running.
+func NewServer() *Server {
+	s := &Server{
+		ClientBuffer: clientBuffer,
+		MaxFileSize:  1 << 20,
+		entering:     make(chan client),
+		leaving:      make(chan client),
+		messages:     make(chan message, queueSize),
+		reports:      make(chan chan report),
+		archive:      make(chan Record, archiveBuffer),
+		archived:     make(chan struct{}),
+		hooks:        make(chan Record, archiveBuffer),
+		done:         make(chan struct{}),
+		listeners:    map[net.Listener]bool{},
+		conns:        map[net.Conn]bool{},
+		transfers:    transfers{byID: map[string]*transfer{}, byToken: map[string]*transfer{}},
 	}
+	go s.broadcaster()
+	go s.archiver()
+	go s.hooker()
+	return s
+}
+
+// Serve accepts chat connections on l until l fails or the server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	return s.serve(l, s.ServeConn)
+}
 
-	go broadcaster() 
+func (s *Server) serve(l net.Listener, handle func(net.Conn)) error {
+	if !track(s, l, s.listeners) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer untrack(s, l, s.listeners)
 	for {
-		conn, err := listener.Accept() 
+		conn, err := l.Accept()
 		if err != nil {
-			log.Print(err) 
-			continue
+			select {
+			case <-s.done:
+				return ErrServerClosed
+			default:
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				log.Print(err)
+				continue
+			}
+			return err
 		}
-		go handleConn(conn) 
+		go handle(conn)
+	}
+}
+
+// Close stops the broadcaster and closes every listener and connection. It
+// returns once the messages waiting to be logged have been, so that the log
+// can then be closed.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
 	}
+	s.mu.Unlock()
+	<-s.archived
+	return nil
 }
 
-func broadcaster() {
-	clients := make(map[client]bool) // all connected clients
+// track records an open listener or connection so Close can find it,
+// reporting false if the server is already closed.
+func track[T comparable](s *Server, v T, set map[T]bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	set[v] = true
+	return true
+}
+
+func untrack[T comparable](s *Server, v T, set map[T]bool) {
+	s.mu.Lock()
+	delete(set, v)
+	s.mu.Unlock()
+}
+
+func (s *Server) broadcaster() {
+	clients := make(map[client]bool)   // all connected clients
+	roomMessages := map[string]int64{} // messages broadcast per room
+	var sent int64                     // messages broadcast since the last tick
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	broadcast := func(msg message) {
+		for cli := range clients {
+			if cli.room == msg.room {
+				s.send(cli, msg.String()) // broadcast message to all clients
+			}
+		}
+		roomMessages[msg.room]++
+		s.stats.messages.Add(1)
+		sent++
+		s.record(msg)
+	}
+
 	for {
 		select {
-		case msg := <-messages:
+		case msg := <-s.messages:
+			if msg.to == "" {
+				broadcast(msg)
+				continue
+			}
+			found := false
 			for cli := range clients {
-				cli.channel <- msg // broadcast message to all clients
+				if cli.name == msg.to {
+					found = true
+					s.send(cli, msg.text)
+				}
+			}
+			if msg.delivered != nil {
+				msg.delivered <- found
 			}
 
-		case cli := <-entering:
-			clients[cli] = true 
+		case cli := <-s.entering:
+			broadcast(message{room: cli.room, text: cli.name + " has arrived"})
+			clients[cli] = true
 			var listofClients string
 			for c := range clients {
 				listofClients += c.name + ", "
 			}
-			cli.channel <- "The number of current clients: " + strconv.Itoa(len(clients)) + ",  " + "List of Current clients: " + listofClients 
+			s.send(cli, "* The number of current clients: "+strconv.Itoa(len(clients))+",  "+"List of Current clients: "+listofClients)
 
-		case cli := <-leaving:
-			delete(clients, cli) 
-			close(cli.channel)   
+		case cli := <-s.leaving:
+			delete(clients, cli)
+			close(cli.channel)
+			broadcast(message{room: cli.room, text: cli.name + " has left"})
+
+		case <-tick.C:
+			s.stats.rate.Store(sent)
+			sent = 0
+
+		case reply := <-s.reports:
+			reply <- newReport(clients, roomMessages)
+
+		case <-s.done:
+			return
 		}
 	}
 }
 
+// send queues msg for cli, dropping it if the client has fallen behind so
+// that one slow client cannot stall everyone else.
+func (s *Server) send(cli client, msg string) {
+	select {
+	case cli.channel <- msg:
+	default:
+		s.stats.dropped.Add(1)
+	}
+}
+
+// post hands msg to the broadcaster, reporting false if the server has closed.
+func (s *Server) post(msg message) bool {
+	select {
+	case s.messages <- msg:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// ServeConn runs a chat session on conn until the client disconnects.
+func (s *Server) ServeConn(conn net.Conn) {
+	if !track(s, conn, s.conns) {
+		conn.Close()
+		return
+	}
+	defer untrack(s, conn, s.conns)
 
-func handleConn(conn net.Conn) {
-	ch := make(chan string) // outgoing client messages
-	go clientWriter(conn, ch)
+	ch := make(chan string, s.ClientBuffer) // outgoing client messages
+	go s.clientWriter(conn, ch)
 
 	who := conn.RemoteAddr().String()
-	cli := client{channel: ch, name: who}
+	cli := client{channel: ch, name: who, addr: who, room: lobby, since: time.Now()}
 
-	ch <- "You are " + who
-	messages <- who + " has arrived"
-	entering <- cli
+	ch <- "* You are " + who
+	select {
+	case s.entering <- cli:
+	case <-s.done:
+		conn.Close()
+		return
+	}
 
 	input := bufio.NewScanner(conn)
 	for input.Scan() {
-		messages <- who + ": " + input.Text()
+		if line := input.Text(); strings.HasPrefix(line, "/") {
+			s.command(cli, line)
+		} else if !s.post(message{room: cli.room, from: who, text: line}) {
+			break
+		}
 	}
 
 	// NOTE: ignoring potential errors from input.Err()
 
-	leaving <- cli
-	messages <- who + " has left"
-	if err := conn.Close(); err != nil {
+	select {
+	case s.leaving <- cli:
+	case <-s.done:
+	}
+	if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
 		log.Println("closing connection:", err)
 	}
 }
 
-func clientWriter(conn net.Conn, ch <-chan string) {
-	for msg := range ch {
-		fmt.Fprintln(conn, msg) // NOTE: ignoring network errors
+// command handles a line starting with "/" sent by cli.
+func (s *Server) command(cli client, line string) {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case "/who":
+		s.who(cli)
+	case "/search":
+		s.search(cli, fields[1:])
+	case "/send":
+		s.offer(cli, fields[1:])
+	case "/accept":
+		s.accept(cli, fields[1:])
+	case "/decline":
+		s.decline(cli, fields[1:])
+	default:
+		if bot, ok := s.Bots[fields[0][1:]]; ok {
+			s.ask(cli, bot, line, fields[1:])
+			return
+		}
+		s.reply(cli, "* unknown command "+fields[0])
+	}
+}
+
+// reply sends a notice to cli from its own connection goroutine.
+func (s *Server) reply(cli client, text string) {
+	select {
+	case cli.channel <- text:
+	case <-s.done:
+	}
+}
+
+// who handles "/who", listing the clients that are online.
+func (s *Server) who(cli client) {
+	var names []string
+	for _, session := range s.report().sessions {
+		names = append(names, session.Name)
+	}
+	s.reply(cli, "* online: "+strings.Join(names, ", "))
+}
+
+func (s *Server) clientWriter(conn net.Conn, ch <-chan string) {
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			fmt.Fprintln(conn, msg) // NOTE: ignoring network errors
+		case <-s.done:
+			return
+		}
 	}
 }
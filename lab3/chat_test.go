@@ -0,0 +1,620 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer runs a server on a loopback listener for the duration of the
+// test, after applying configure to it.
+func startServer(t *testing.T, configure func(*Server)) (*Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	if configure != nil {
+		configure(s)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+// A testClient is one end of a chat connection driven by a test.
+type testClient struct {
+	t     *testing.T
+	conn  net.Conn
+	lines *bufio.Scanner
+	name  string
+}
+
+// dial connects to the server and consumes its greeting.
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &testClient{t: t, conn: conn, lines: bufio.NewScanner(conn), name: conn.LocalAddr().String()}
+	c.expect("* You are " + c.name)
+	c.expectPrefix("* The number of current clients: ")
+	return c
+}
+
+func (c *testClient) send(line string) {
+	c.t.Helper()
+	if _, err := fmt.Fprintln(c.conn, line); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) read() string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if !c.lines.Scan() {
+		c.t.Fatalf("%s: connection ended: %v", c.name, c.lines.Err())
+	}
+	return c.lines.Text()
+}
+
+func (c *testClient) expect(want string) {
+	c.t.Helper()
+	if got := c.read(); got != want {
+		c.t.Fatalf("%s: got %q, want %q", c.name, got, want)
+	}
+}
+
+func (c *testClient) expectPrefix(prefix string) string {
+	c.t.Helper()
+	got := c.read()
+	if !strings.HasPrefix(got, prefix) {
+		c.t.Fatalf("%s: got %q, want prefix %q", c.name, got, prefix)
+	}
+	return got
+}
+
+// waitForClients waits until the broadcaster has registered n clients.
+func waitForClients(t *testing.T, s *Server, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.report().sessions) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d clients, want %d", len(s.report().sessions), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestJoinAndLeaveNotices(t *testing.T) {
+	t.Parallel()
+	s, addr := startServer(t, nil)
+	a := dial(t, addr)
+	waitForClients(t, s, 1)
+	b := dial(t, addr)
+	a.expect("* " + b.name + " has arrived")
+
+	b.send("hello")
+	a.expect(b.name + ": hello")
+	b.expect(b.name + ": hello")
+
+	b.conn.Close()
+	a.expect("* " + b.name + " has left")
+}
+
+func TestBroadcastOrdering(t *testing.T) {
+	t.Parallel()
+	const clients, messages = 200, 5
+	s, addr := startServer(t, func(s *Server) { s.ClientBuffer = clients * (messages + 1) })
+
+	var cs []*testClient
+	for i := 0; i < clients; i++ {
+		cs = append(cs, dial(t, addr))
+	}
+	waitForClients(t, s, clients)
+
+	// Every client sends concurrently, and every client reads concurrently.
+	// Each must see every message, in the order its sender sent them, and
+	// all of them must see the same overall order.
+	var wg sync.WaitGroup
+	seen := make([][]string, clients)
+	for i, c := range cs {
+		wg.Add(2)
+		go func(c *testClient) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				fmt.Fprintf(c.conn, "message %d\n", j)
+			}
+		}(c)
+		go func(i int, c *testClient) {
+			defer wg.Done()
+			for len(seen[i]) < clients*messages {
+				c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+				if !c.lines.Scan() {
+					return
+				}
+				if line := c.lines.Text(); !strings.HasPrefix(line, "* ") {
+					seen[i] = append(seen[i], line)
+				}
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	for i := range cs {
+		if len(seen[i]) != clients*messages {
+			t.Fatalf("client %d saw %d messages, want %d", i, len(seen[i]), clients*messages)
+		}
+		for j := range seen[i] {
+			if seen[i][j] != seen[0][j] {
+				t.Fatalf("client %d message %d is %q; client 0 saw %q", i, j, seen[i][j], seen[0][j])
+			}
+		}
+	}
+	next := map[string]int{}
+	for _, line := range seen[0] {
+		from, text, _ := strings.Cut(line, ": ")
+		if want := fmt.Sprintf("message %d", next[from]); text != want {
+			t.Fatalf("from %s got %q, want %q", from, text, want)
+		}
+		next[from]++
+	}
+	if dropped := s.stats.dropped.Load(); dropped != 0 {
+		t.Errorf("dropped %d messages", dropped)
+	}
+}
+
+func TestSlowClientIsDropped(t *testing.T) {
+	t.Parallel()
+	s, addr := startServer(t, func(s *Server) { s.ClientBuffer = 4 })
+
+	// A pipe has no buffering, so a client that stops reading from one
+	// falls behind as soon as its queue fills.
+	server, slow := net.Pipe()
+	defer slow.Close()
+	go s.ServeConn(server)
+	waitForClients(t, s, 1)
+	fast := dial(t, addr)
+
+	for i := 0; i < 20; i++ {
+		fast.send(fmt.Sprintf("message %d", i))
+		fast.expect(fmt.Sprintf("%s: message %d", fast.name, i))
+	}
+	if s.stats.dropped.Load() == 0 {
+		t.Error("no messages were dropped for the slow client")
+	}
+}
+
+func TestServeConnOverPipe(t *testing.T) {
+	t.Parallel()
+	s := NewServer()
+	defer s.Close()
+	server, conn := net.Pipe()
+	go s.ServeConn(server)
+
+	c := &testClient{t: t, conn: conn, lines: bufio.NewScanner(conn), name: "pipe"}
+	c.expect("* You are pipe")
+	c.expectPrefix("* The number of current clients: 1")
+	c.send("/who")
+	c.expect("* online: pipe")
+	c.send("hello")
+	c.expect("pipe: hello")
+}
+
+func TestFileTransfer(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, addr := startServer(t, func(s *Server) { s.TransferAddr = l.Addr().String() })
+	go s.ServeTransfers(l)
+
+	a := dial(t, addr)
+	waitForClients(t, s, 1)
+	b := dial(t, addr)
+	a.expect("* " + b.name + " has arrived")
+
+	const contents = "a log snippet\n"
+	a.send(fmt.Sprintf("/send %s /tmp/log.txt %d", b.name, len(contents)))
+	var id string
+	fmt.Sscanf(a.expectPrefix("* transfer "), "* transfer %8s", &id)
+	b.expect(fmt.Sprintf("* transfer %s: %s offers log.txt (%d bytes); reply /accept %[1]s or /decline %[1]s", id, a.name, len(contents)))
+
+	b.send("/accept " + id)
+	var download, upload string
+	fmt.Sscanf(b.read(), "* transfer "+id+": download log.txt from "+s.TransferAddr+" with token %s", &download)
+	fmt.Sscanf(a.read(), "* transfer "+id+": upload log.txt to "+s.TransferAddr+" with token %s", &upload)
+
+	up, err := net.Dial("tcp", s.TransferAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(up, "%s\n%s", upload, contents)
+	up.Close()
+	down, err := net.Dial("tcp", s.TransferAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(down, download)
+	got, err := io.ReadAll(down)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != contents {
+		t.Errorf("received %q, want %q", got, contents)
+	}
+	a.expect(fmt.Sprintf("* transfer %s: sent log.txt (%d bytes) to %s", id, len(contents), b.name))
+	b.expect(fmt.Sprintf("* transfer %s: received log.txt (%d bytes) from %s", id, len(contents), a.name))
+}
+
+func TestFileTransferTooLarge(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, addr := startServer(t, func(s *Server) {
+		s.TransferAddr = l.Addr().String()
+		s.MaxFileSize = 8
+	})
+	go s.ServeTransfers(l)
+
+	a := dial(t, addr)
+	waitForClients(t, s, 1)
+	b := dial(t, addr)
+	a.expect("* " + b.name + " has arrived")
+
+	// The size is not given, so only the upload shows it is too large.
+	a.send("/send " + b.name + " /tmp/big.bin")
+	var id string
+	fmt.Sscanf(a.expectPrefix("* transfer "), "* transfer %8s", &id)
+	b.expectPrefix("* transfer " + id + ": ")
+	b.send("/accept " + id)
+	var download, upload string
+	fmt.Sscanf(b.read(), "* transfer "+id+": download big.bin from "+s.TransferAddr+" with token %s", &download)
+	fmt.Sscanf(a.read(), "* transfer "+id+": upload big.bin to "+s.TransferAddr+" with token %s", &upload)
+
+	up, err := net.Dial("tcp", s.TransferAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(up, "%s\n%s", upload, "0123456789")
+	up.Close()
+	down, err := net.Dial("tcp", s.TransferAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(down, download)
+	got, err := io.ReadAll(down)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "01234567" {
+		t.Errorf("received %q, want only the first 8 bytes", got)
+	}
+	want := fmt.Sprintf("* transfer %s: big.bin aborted; it exceeds 8 bytes", id)
+	a.expect(want)
+	b.expect(want)
+}
+
+func TestFileTransferDecline(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, addr := startServer(t, func(s *Server) { s.TransferAddr = l.Addr().String() })
+	go s.ServeTransfers(l)
+
+	a := dial(t, addr)
+	waitForClients(t, s, 1)
+	b := dial(t, addr)
+	a.expect("* " + b.name + " has arrived")
+
+	a.send("/send " + b.name + " /tmp/log.txt 3")
+	var id string
+	fmt.Sscanf(a.expectPrefix("* transfer "), "* transfer %8s", &id)
+	b.expectPrefix("* transfer " + id + ": ")
+
+	b.send("/decline " + id)
+	b.expect("* transfer " + id + ": declined")
+	a.expect(fmt.Sprintf("* transfer %s: %s declined log.txt", id, b.name))
+
+	// The offer is gone.
+	b.send("/accept " + id)
+	b.expect(fmt.Sprintf("* no pending transfer %q", id))
+}
+
+func TestSearchChatLog(t *testing.T) {
+	t.Parallel()
+	l, err := openFileLog(filepath.Join(t.TempDir(), "chat.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s, addr := startServer(t, func(s *Server) { s.Log = l })
+	a := dial(t, addr)
+	waitForClients(t, s, 1)
+	b := dial(t, addr)
+	a.expect("* " + b.name + " has arrived")
+
+	a.send("the build is broken")
+	a.expect(a.name + ": the build is broken")
+	b.send("which build?")
+	b.send("never mind, the Build is fixed")
+	a.expect(b.name + ": which build?")
+	a.expect(b.name + ": never mind, the Build is fixed")
+
+	// The archiver writes in the background, so wait for it to catch up.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		found, err := l.Search(context.Background(), Query{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) == 5 { // two arrivals and three messages
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("chat log has %d records, want 5", len(found))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	a.send("/search build is")
+	a.expect("* search: 2 messages")
+	a.expectPrefix("* ")
+	if got := a.read(); !strings.HasSuffix(got, " "+b.name+": never mind, the Build is fixed") {
+		t.Errorf("got %q, want the second match", got)
+	}
+
+	found, err := l.Search(context.Background(), Query{From: b.name, Terms: []string{"build"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0].Text != "which build?" || found[0].Room != lobby {
+		t.Errorf("search by user returned %+v", found)
+	}
+	until := found[0].Time
+	found, err = l.Search(context.Background(), Query{Until: until})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 || found[2].Text != "the build is broken" {
+		t.Errorf("search before %v returned %+v", until, found)
+	}
+}
+
+func TestFileLogLongLine(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	l, err := openFileLog(filepath.Join(t.TempDir(), "chat.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// Each "<" is escaped as six bytes, making a line far longer than the
+	// longest message a client can send.
+	long := strings.Repeat("<", 64*1024)
+	for _, text := range []string{long, "after the long one"} {
+		if err := l.Append(ctx, Record{Time: time.Now().UTC(), Room: lobby, Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found, err := l.Search(ctx, Query{})
+	if err != nil || len(found) != 2 || found[0].Text != long || found[1].Text != "after the long one" {
+		t.Fatalf("Search = %d records, %v; want both", len(found), err)
+	}
+}
+
+// A slowLog is a chat log that takes a while to append each record.
+type slowLog struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+func (l *slowLog) Append(ctx context.Context, r Record) error {
+	time.Sleep(time.Millisecond)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, r)
+	return nil
+}
+
+func (l *slowLog) Search(ctx context.Context, q Query) ([]Record, error) { return nil, nil }
+
+func (l *slowLog) Close() error { return nil }
+
+func TestCloseArchivesQueued(t *testing.T) {
+	t.Parallel()
+	l := &slowLog{}
+	s := NewServer()
+	s.Log = l
+	const n = 50
+	for i := 0; i < n; i++ {
+		s.record(message{room: lobby, from: "a", text: fmt.Sprint(i)})
+	}
+	s.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.records) != n || l.records[n-1].Text != fmt.Sprint(n-1) {
+		t.Errorf("after Close, the log has %d records, want %d", len(l.records), n)
+	}
+}
+
+// An echoBot answers with its arguments.
+type echoBot struct{}
+
+func (echoBot) Name() string { return "echo" }
+
+func (echoBot) Answer(ctx context.Context, args []string) (string, error) {
+	return strings.Join(args, " "), nil
+}
+
+func TestBot(t *testing.T) {
+	t.Parallel()
+	s, addr := startServer(t, func(s *Server) { s.Bots = map[string]Bot{"echo": echoBot{}} })
+	a := dial(t, addr)
+	waitForClients(t, s, 1)
+	b := dial(t, addr)
+	a.expect("* " + b.name + " has arrived")
+
+	b.send("/echo hello  there")
+	a.expect(b.name + ": /echo hello  there")
+	a.expect("echo: hello there")
+	b.expect(b.name + ": /echo hello  there")
+	b.expect("echo: hello there")
+}
+
+func TestWebhooks(t *testing.T) {
+	t.Parallel()
+	posted := make(chan Record, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var r Record
+		if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+			t.Error(err)
+		}
+		posted <- r
+	}))
+	defer hook.Close()
+	s, addr := startServer(t, func(s *Server) {
+		s.Webhooks = map[string][]string{lobby: {hook.URL}}
+		s.WebhookSecret = "s3cret"
+	})
+	admin := httptest.NewServer(s.AdminHandler())
+	defer admin.Close()
+
+	a := dial(t, addr)
+	waitForClients(t, s, 1)
+	if r := <-posted; r.Text != a.name+" has arrived" {
+		t.Errorf("posted %+v, want the arrival notice", r)
+	}
+	a.send("hello")
+	if r := <-posted; r.From != a.name || r.Text != "hello" || r.Room != lobby {
+		t.Errorf("posted %+v, want hello from %s", r, a.name)
+	}
+
+	incoming := func(secret, body string) int {
+		req, _ := http.NewRequest("POST", admin.URL+"/webhook", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+secret)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := incoming("wrong", `{"from":"ci","text":"build passed"}`); code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: got status %d", code)
+	}
+	if code := incoming("s3cret", `{"text":"anonymous"}`); code != http.StatusBadRequest {
+		t.Errorf("missing bot name: got status %d", code)
+	}
+	if code := incoming("s3cret", `{"from":"ci","text":"build passed\ndeployed"}`); code != http.StatusAccepted {
+		t.Errorf("got status %d, want %d", code, http.StatusAccepted)
+	}
+	a.expect(a.name + ": hello")
+	a.expect("ci: build passed")
+	a.expect("ci: deployed")
+
+	// Injected messages are not posted back out.
+	a.send("thanks")
+	if r := <-posted; r.Text != "thanks" {
+		t.Errorf("posted %+v, want only the reply", r)
+	}
+}
+
+func TestAdminMetrics(t *testing.T) {
+	t.Parallel()
+	s, addr := startServer(t, func(s *Server) { s.WebhookSecret = "s3cret" })
+	admin := httptest.NewServer(s.AdminHandler())
+	defer admin.Close()
+	a := dial(t, addr)
+	waitForClients(t, s, 1)
+	a.send("hello")
+	a.expect(a.name + ": hello")
+
+	// Room names are any text, so their labels must be escaped.
+	req, _ := http.NewRequest("POST", admin.URL+"/webhook", strings.NewReader(`{"from":"ci","room":"café \"a\\b\"\nc","text":"x"}`))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := []string{
+		"chat_connected_clients 1",
+		`chat_room_clients{room="lobby"} 1`,
+		`chat_room_messages_total{room="lobby"} 2`, // the arrival and hello
+		`chat_room_messages_total{room="café \"a\\b\"\nc"} 1`,
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(admin.URL + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		var missing []string
+		for _, line := range want {
+			if !strings.Contains(string(body), line+"\n") {
+				missing = append(missing, line)
+			}
+		}
+		if len(missing) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics lack %q:\n%s", missing, body)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdminClients(t *testing.T) {
+	t.Parallel()
+	s, addr := startServer(t, nil)
+	admin := httptest.NewServer(s.AdminHandler())
+	defer admin.Close()
+	sessions := func() []session {
+		t.Helper()
+		resp, err := http.Get(admin.URL + "/clients")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var got []session
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if got := sessions(); got == nil || len(got) != 0 {
+		t.Errorf("with no clients, got %+v, want an empty list", got)
+	}
+	a := dial(t, addr)
+	waitForClients(t, s, 1)
+	b := dial(t, addr)
+	waitForClients(t, s, 2)
+	got := sessions()
+	if len(got) != 2 || got[0].Name != a.name || got[1].Name != b.name || got[0].Room != lobby || got[0].ConnectedAt.IsZero() {
+		t.Errorf("got %+v, want %s then %s in the lobby", got, a.name, b.name)
+	}
+}
@@ -0,0 +1,215 @@
+// Chatclient is a terminal client for the chat server.
+//
+// Messages scroll in the upper part of the screen while the line being typed
+// stays at the bottom, below a status line listing the users who are online.
+// If the connection drops, the client reconnects with exponential backoff.
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	addr      = flag.String("addr", "localhost:8000", "address of the chat server")
+	useTLS    = flag.Bool("tls", false, "connect to the server using TLS")
+	caFile    = flag.String("ca", "", "PEM file of CAs trusted to sign the server certificate (system roots if empty)")
+	insecure  = flag.Bool("insecure", false, "do not verify the server certificate")
+	downloads = flag.String("downloads", ".", "directory where received files are saved")
+)
+
+const (
+	minBackoff = time.Second      // Delay before the first reconnection attempt.
+	maxBackoff = 30 * time.Second // Longest delay between reconnection attempts.
+)
+
+// A chat is the client's connection to the server and the state that
+// outlives any single connection.
+type chat struct {
+	ui        *ui
+	tlsConfig *tls.Config // nil for plain TCP
+
+	mu      sync.Mutex
+	offers  map[string][]string // local paths offered but not yet given an id, by base name
+	uploads map[string]string   // local paths of offered files, by transfer id
+}
+
+func main() {
+	flag.Parse()
+	c := &chat{ui: newUI(os.Stdout), offers: map[string][]string{}, uploads: map[string]string{}}
+	if *useTLS {
+		config, err := clientTLS()
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.tlsConfig = config
+	}
+	defer c.ui.close()
+
+	input := make(chan string) // lines typed by the user
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			input <- scanner.Text()
+		}
+		close(input)
+	}()
+	c.run(input)
+}
+
+// clientTLS builds the TLS configuration from the command-line flags.
+func clientTLS() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: *insecure}
+	if *caFile != "" {
+		pem, err := os.ReadFile(*caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", *caFile)
+		}
+	}
+	return config, nil
+}
+
+// run keeps the client connected until the user quits, backing off
+// exponentially between failed connection attempts. The delay starts over
+// once a connection has stayed up for longer than the maximum backoff.
+func (c *chat) run(input <-chan string) {
+	backoff := minBackoff
+	for {
+		c.ui.setStatus("connecting to " + *addr)
+		conn, err := c.dial(*addr)
+		if err != nil {
+			c.ui.system(fmt.Sprintf("* cannot connect to %s: %v; retrying in %s", *addr, err, backoff))
+			c.ui.setStatus("disconnected")
+		} else {
+			c.ui.setStatus("connected to " + *addr)
+			start := time.Now()
+			if quit := c.session(conn, input); quit {
+				return
+			}
+			if time.Since(start) > maxBackoff {
+				backoff = minBackoff
+			}
+			c.ui.setUsers(nil)
+			c.ui.setStatus("disconnected")
+			c.ui.system(fmt.Sprintf("* connection lost; reconnecting in %s", backoff))
+		}
+		if !c.wait(backoff, input) {
+			return
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// dial connects to addr, using TLS if it is enabled.
+func (c *chat) dial(addr string) (net.Conn, error) {
+	if c.tlsConfig != nil {
+		return tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", addr, c.tlsConfig)
+	}
+	return net.DialTimeout("tcp", addr, 10*time.Second)
+}
+
+// wait sleeps for d while telling the user that typed lines cannot be sent.
+// It reports false if the user quit in the meantime.
+func (c *chat) wait(d time.Duration, input <-chan string) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case line, ok := <-input:
+			if !ok || line == "/quit" {
+				return false
+			}
+			c.ui.system("* not connected; message not sent")
+		}
+	}
+}
+
+// session relays lines between the user and the server until either side
+// goes away. It reports true if the user quit.
+func (c *chat) session(conn net.Conn, input <-chan string) bool {
+	defer conn.Close()
+	incoming := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(incoming)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			select {
+			case incoming <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	fmt.Fprintln(conn, "/who")
+	for {
+		select {
+		case line, ok := <-incoming:
+			if !ok {
+				return false
+			}
+			c.receive(conn, line)
+		case line, ok := <-input:
+			c.ui.prompt()
+			if !ok || line == "/quit" {
+				return true
+			}
+			if err := c.submit(conn, line); err != nil {
+				return false
+			}
+		}
+	}
+}
+
+// receive handles a line sent by the server.
+func (c *chat) receive(conn net.Conn, line string) {
+	if !strings.HasPrefix(line, "* ") {
+		c.ui.message(line)
+		return
+	}
+	notice := strings.TrimPrefix(line, "* ")
+	switch {
+	case strings.HasPrefix(notice, "online: "):
+		c.ui.setUsers(strings.Split(strings.TrimPrefix(notice, "online: "), ", "))
+		return
+	case strings.HasSuffix(notice, " has arrived"), strings.HasSuffix(notice, " has left"):
+		fmt.Fprintln(conn, "/who") // refresh the user list
+	case strings.HasPrefix(notice, "transfer "):
+		c.transferNotice(notice)
+	}
+	c.ui.system(line)
+}
+
+// submit sends a line typed by the user to the server.
+func (c *chat) submit(conn net.Conn, line string) error {
+	if fields := strings.Fields(line); len(fields) == 3 && fields[0] == "/send" {
+		var err error
+		if line, err = c.offer(fields[1], fields[2]); err != nil {
+			c.ui.system("* " + err.Error())
+			return nil
+		}
+	}
+	_, err := fmt.Fprintln(conn, line)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		c.ui.system("* sending: " + err.Error())
+	}
+	return err
+}
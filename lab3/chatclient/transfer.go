@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// offer prepares "/send <nick> <path>" for the server, which also wants the
+// file size so the receiver knows what is being offered.
+func (c *chat) offer(nick, path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", path)
+	}
+	name := filepath.Base(path)
+	c.mu.Lock()
+	c.offers[name] = append(c.offers[name], path)
+	c.mu.Unlock()
+	return fmt.Sprintf("/send %s %s %d", nick, name, info.Size()), nil
+}
+
+// transferNotice follows the server's notices about a file transfer,
+// connecting to the transfer listener when it is this client's turn.
+func (c *chat) transferNotice(notice string) {
+	f := strings.Fields(notice)
+	if len(f) < 3 {
+		return
+	}
+	id := strings.TrimSuffix(f[1], ":")
+	switch {
+	case f[2] == "offered" && len(f) == 6: // transfer ID: offered NAME to NICK
+		c.mu.Lock()
+		if paths := c.offers[f[3]]; len(paths) > 0 {
+			c.uploads[id] = paths[0]
+			c.offers[f[3]] = paths[1:]
+		}
+		c.mu.Unlock()
+	case f[2] == "upload" && len(f) == 9: // transfer ID: upload NAME to ADDR with token T
+		c.mu.Lock()
+		path, ok := c.uploads[id]
+		delete(c.uploads, id)
+		c.mu.Unlock()
+		if ok {
+			go c.upload(id, path, c.transferAddr(f[5]), f[8])
+		}
+	case f[2] == "download" && len(f) == 9: // transfer ID: download NAME from ADDR with token T
+		go c.download(id, f[3], c.transferAddr(f[5]), f[8])
+	case len(f) == 5 && f[3] == "declined", f[len(f)-1] == "expired":
+		c.mu.Lock()
+		delete(c.uploads, id)
+		c.mu.Unlock()
+	}
+}
+
+// transferAddr resolves the address announced by the server. A server
+// listening on a loopback or unspecified address announces a host that is
+// only meaningful to itself, so the chat server's host is used instead.
+func (c *chat) transferAddr(announced string) string {
+	host, port, err := net.SplitHostPort(announced)
+	if err != nil {
+		return announced
+	}
+	if ip := net.ParseIP(host); host == "" || host == "localhost" || ip != nil && (ip.IsLoopback() || ip.IsUnspecified()) {
+		if serverHost, _, err := net.SplitHostPort(*addr); err == nil {
+			return net.JoinHostPort(serverHost, port)
+		}
+	}
+	return announced
+}
+
+// upload sends the file at path to the transfer listener.
+func (c *chat) upload(id, path, addr, token string) {
+	err := func() error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		conn, err := c.dial(addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if _, err := fmt.Fprintln(conn, token); err != nil {
+			return err
+		}
+		_, err = io.Copy(conn, f)
+		return err
+	}()
+	if err != nil {
+		c.ui.system(fmt.Sprintf("* transfer %s: upload failed: %v", id, err))
+	}
+}
+
+// download saves the file named name from the transfer listener into the
+// downloads directory, refusing to overwrite an existing file.
+func (c *chat) download(id, name, addr, token string) {
+	path := filepath.Join(*downloads, filepath.Base(name))
+	err := func() error {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
+		}
+		conn, err := c.dial(addr)
+		if err != nil {
+			f.Close()
+			os.Remove(path)
+			return err
+		}
+		defer conn.Close()
+		if _, err := fmt.Fprintln(conn, token); err != nil {
+			f.Close()
+			os.Remove(path)
+			return err
+		}
+		if _, err := io.Copy(f, conn); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}()
+	if err != nil {
+		c.ui.system(fmt.Sprintf("* transfer %s: download failed: %v", id, err))
+		return
+	}
+	c.ui.system(fmt.Sprintf("* transfer %s: saved %s", id, path))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// ANSI escape sequences used to lay out the screen.
+const (
+	saveCursor    = "\x1b7"
+	restoreCursor = "\x1b8"
+	clearScreen   = "\x1b[2J"
+	clearLine     = "\x1b[2K"
+	reverse       = "\x1b[7m"
+	yellow        = "\x1b[33m"
+	reset         = "\x1b[0m"
+	prompt        = "> "
+)
+
+// A ui draws the chat on a terminal: a scrolling message pane, a status line
+// with the online users, and the input line at the bottom. Input is read in
+// the terminal's normal line mode, so the pane is confined to a scrolling
+// region and everything else is drawn around the cursor. When the output is
+// not a terminal, lines are simply printed as they arrive.
+type ui struct {
+	mu         sync.Mutex
+	out        io.Writer
+	fd         int
+	tty        bool
+	rows, cols int
+	status     string
+	users      []string
+}
+
+func newUI(out *os.File) *ui {
+	u := &ui{out: out, fd: int(out.Fd())}
+	u.tty = term.IsTerminal(u.fd)
+	if u.tty {
+		u.mu.Lock()
+		u.layout()
+		u.mu.Unlock()
+	}
+	return u
+}
+
+// layout sizes the scrolling region to the terminal and redraws the fixed
+// lines. The caller must hold u.mu.
+func (u *ui) layout() {
+	cols, rows, err := term.GetSize(u.fd)
+	if err != nil || rows < 3 {
+		cols, rows = 80, 24
+	}
+	if rows == u.rows && cols == u.cols {
+		return
+	}
+	u.rows, u.cols = rows, cols
+	fmt.Fprintf(u.out, "%s\x1b[1;%dr", clearScreen, u.rows-2)
+	u.drawStatus()
+	fmt.Fprintf(u.out, "\x1b[%d;1H%s%s", u.rows, clearLine, prompt)
+}
+
+// message shows a chat line in the pane.
+func (u *ui) message(line string) { u.print(line) }
+
+// system shows a server or client notice in the pane, set apart by colour.
+func (u *ui) system(line string) {
+	if u.tty {
+		line = yellow + line + reset
+	}
+	u.print(line)
+}
+
+func (u *ui) print(line string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.tty {
+		fmt.Fprintln(u.out, line)
+		return
+	}
+	u.layout()
+	// Scroll the pane by writing a newline at its last row, then restore
+	// the cursor to wherever the user was typing.
+	fmt.Fprintf(u.out, "%s\x1b[%d;1H\n%s%s", saveCursor, u.rows-2, line, restoreCursor)
+}
+
+// setStatus describes the connection on the status line.
+func (u *ui) setStatus(status string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.status = status
+	if !u.tty {
+		fmt.Fprintln(u.out, "* "+status)
+		return
+	}
+	u.redrawStatus()
+}
+
+// setUsers replaces the list of online users on the status line.
+func (u *ui) setUsers(users []string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.users = users
+	if u.tty {
+		u.redrawStatus()
+	}
+}
+
+// prompt clears the input line after the user has entered a line.
+func (u *ui) prompt() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.tty {
+		return
+	}
+	u.layout()
+	u.drawStatus() // the terminal may have scrolled when the line was entered
+	fmt.Fprintf(u.out, "\x1b[%d;1H%s%s", u.rows, clearLine, prompt)
+}
+
+func (u *ui) redrawStatus() {
+	fmt.Fprint(u.out, saveCursor)
+	u.layout()
+	u.drawStatus()
+	fmt.Fprint(u.out, restoreCursor)
+}
+
+// drawStatus writes the status line. The caller must hold u.mu.
+func (u *ui) drawStatus() {
+	status := fmt.Sprintf(" %s | online (%d): %s", u.status, len(u.users), strings.Join(u.users, ", "))
+	if len(status) > u.cols {
+		status = status[:u.cols]
+	}
+	fmt.Fprintf(u.out, "\x1b[%d;1H%s%s%-*s%s", u.rows-1, clearLine, reverse, u.cols, status, reset)
+}
+
+// close restores the terminal's full-screen scrolling.
+func (u *ui) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.tty {
+		fmt.Fprintf(u.out, "\x1b[r\x1b[%d;1H\n", u.rows)
+	}
+}
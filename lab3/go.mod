@@ -0,0 +1,28 @@
+module github.com/VahidBabaey/CloudComputing/lab3
+
+go 1.22.1
+
+require (
+	github.com/VahidBabaey/CloudComputing/config v0.0.0
+	github.com/VahidBabaey/CloudComputing/lab6 v0.0.0
+	go.mongodb.org/mongo-driver v1.14.0
+	golang.org/x/term v0.18.0
+)
+
+require (
+	github.com/golang/snappy v0.0.1 // indirect
+	github.com/klauspost/compress v1.13.6 // indirect
+	github.com/montanaflynn/stats v0.0.0-20171201202039-1bf9dbcd8cbe // indirect
+	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
+	github.com/xdg-go/scram v1.1.2 // indirect
+	github.com/xdg-go/stringprep v1.0.4 // indirect
+	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
+	golang.org/x/crypto v0.17.0 // indirect
+	golang.org/x/sync v0.1.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+)
+
+replace github.com/VahidBabaey/CloudComputing/lab6 => ../lab6
+
+replace github.com/VahidBabaey/CloudComputing/config => ../config
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/VahidBabaey/CloudComputing/config"
+	"github.com/VahidBabaey/CloudComputing/lab6/weather"
+)
+
+var (
+	addr         = flag.String("addr", "localhost:8000", "address of the chat listener")
+	adminAddr    = flag.String("admin", "", "address of the HTTP admin listener (disabled if empty)")
+	certFile     = flag.String("cert", "", "TLS certificate file; serves TLS when set together with -key")
+	keyFile      = flag.String("key", "", "TLS private key file")
+	transferAddr = flag.String("transfer", "localhost:8001", "address of the file transfer listener (disabled if empty)")
+	maxFileSize  = flag.Int64("maxfile", 1<<20, "largest file, in bytes, that may be sent between clients")
+	chatLog      = flag.String("log", "", "file, or mongodb:// URI, where broadcast messages are kept (disabled if empty)")
+	hookSecret   = flag.String("webhook-secret", "", "secret required by the incoming webhook (disabled if empty)")
+	weatherKey   = flag.String("weather-key", "", "OpenWeatherMap API key for the weather bot (disabled if empty)")
+	webhooks     = webhookFlag{}
+)
+
+func init() {
+	flag.Var(webhooks, "webhook", "post each message in a room to a URL, given as room=url (repeatable)")
+}
+
+func main() {
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], config.Options{
+		EnvPrefix: "CHAT",
+		Env:       map[string]string{"weather-key": "OPENWEATHERMAP_API_KEY"},
+		Validate:  validate,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("config:\n%s", cfg)
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves chat until a listener fails or the process is interrupted or
+// terminated. Either way it then closes the server, which logs the messages
+// still waiting to be, and then the chat log.
+func run() error {
+	s := NewServer()
+	s.TransferAddr = *transferAddr
+	s.MaxFileSize = *maxFileSize
+	s.Webhooks = webhooks
+	s.WebhookSecret = *hookSecret
+	s.Bots = map[string]Bot{}
+	if *weatherKey != "" {
+		s.Bots["weather"] = weatherBot{weather.NewClient(*weatherKey)}
+	}
+	if *chatLog != "" {
+		l, err := openLog(*chatLog)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		s.Log = l
+	}
+	defer s.Close()
+
+	listener, err := listen(*addr)
+	if err != nil {
+		return err
+	}
+	errc := make(chan error, 3)
+	if *adminAddr != "" {
+		go func() { errc <- http.ListenAndServe(*adminAddr, s.AdminHandler()) }()
+	}
+	if *transferAddr != "" {
+		transfers, err := listen(*transferAddr)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		go func() { errc <- s.ServeTransfers(transfers) }()
+	}
+	go func() { errc <- s.Serve(listener) }()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case <-ctx.Done():
+		log.Print("shutting down")
+		return nil
+	case err := <-errc:
+		return err
+	}
+}
+
+// validate checks the listener addresses, which are needed before a
+// client connects to report a mistake.
+func validate() error {
+	errs := []error{config.CheckAddr("addr", *addr)}
+	if *adminAddr != "" {
+		errs = append(errs, config.CheckAddr("admin", *adminAddr))
+	}
+	if *transferAddr != "" {
+		errs = append(errs, config.CheckAddr("transfer", *transferAddr))
+	}
+	return errors.Join(errs...)
+}
+
+// openLog opens the chat log named by a file path or MongoDB URI.
+func openLog(name string) (Log, error) {
+	if strings.HasPrefix(name, "mongodb://") || strings.HasPrefix(name, "mongodb+srv://") {
+		return openMongoLog(name)
+	}
+	return openFileLog(name)
+}
+
+// listen announces on addr, wrapping connections in TLS when a certificate is configured.
+func listen(addr string) (net.Listener, error) {
+	if *certFile == "" && *keyFile == "" {
+		return net.Listen("tcp", addr)
+	}
+	cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", addr, &tls.Config{Certificates: []tls.Certificate{cert}})
+}
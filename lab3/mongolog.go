@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A mongoLog keeps records in the chat.messages collection of a MongoDB
+// server, such as the one run by the lab8 Docker Compose file.
+type mongoLog struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+// openMongoLog connects to the MongoDB server at uri and makes sure the
+// indexes used by searches exist.
+func openMongoLog(uri string) (*mongoLog, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+	collection := client.Database("chat").Collection("messages")
+	_, err = collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "room", Value: 1}, {Key: "time", Value: 1}}},
+		{Keys: bson.D{{Key: "from", Value: 1}, {Key: "time", Value: 1}}},
+	})
+	if err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	return &mongoLog{client: client, collection: collection}, nil
+}
+
+func (l *mongoLog) Append(ctx context.Context, r Record) error {
+	_, err := l.collection.InsertOne(ctx, r)
+	return err
+}
+
+// Search finds the most recent matches newest first, then reverses them.
+func (l *mongoLog) Search(ctx context.Context, q Query) ([]Record, error) {
+	filter := bson.M{}
+	timeRange := bson.M{}
+	if !q.Since.IsZero() {
+		timeRange["$gte"] = q.Since
+	}
+	if !q.Until.IsZero() {
+		timeRange["$lt"] = q.Until
+	}
+	if len(timeRange) > 0 {
+		filter["time"] = timeRange
+	}
+	if q.Room != "" {
+		filter["room"] = q.Room
+	}
+	if q.From != "" {
+		filter["from"] = q.From
+	}
+	if len(q.Terms) > 0 {
+		var terms bson.A
+		for _, term := range q.Terms {
+			terms = append(terms, bson.M{"text": bson.M{"$regex": regexp.QuoteMeta(term), "$options": "i"}})
+		}
+		filter["$and"] = terms
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: -1}})
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+	cursor, err := l.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var found []Record
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+		found[i], found[j] = found[j], found[i]
+	}
+	return found, nil
+}
+
+func (l *mongoLog) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return l.client.Disconnect(ctx)
+}
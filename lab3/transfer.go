@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	offerTimeout    = 2 * time.Minute  // How long a receiver has to accept an offer.
+	transferTimeout = 2 * time.Minute  // How long both sides have to connect once an offer is accepted.
+	tokenTimeout    = 10 * time.Second // How long a transfer connection has to present its token.
+)
+
+// A transfer is a file offered by one client to another. Once the receiver
+// accepts, both sides connect to the transfer listener, each presenting its
+// own token, and the server copies the bytes from one connection to the other
+// without involving the broadcaster.
+type transfer struct {
+	id       string
+	from, to string   // Names of the sending and receiving clients.
+	name     string   // Base name of the file being sent.
+	size     int64    // Size announced by the sender, or -1 if unknown.
+	upload   string   // Token the sender presents; empty until accepted.
+	download string   // Token the receiver presents; empty until accepted.
+	waiting  net.Conn // Whichever side connected first.
+	timer    *time.Timer
+}
+
+// transfers indexes pending transfers by id and by token.
+type transfers struct {
+	sync.Mutex
+	byID    map[string]*transfer
+	byToken map[string]*transfer
+}
+
+// offer handles "/send <nick> <path> [size]".
+func (s *Server) offer(cli client, args []string) {
+	if s.TransferAddr == "" {
+		s.reply(cli, "* file transfer is disabled")
+		return
+	}
+	if len(args) < 2 || len(args) > 3 {
+		s.reply(cli, "* usage: /send <nick> <path> [size]")
+		return
+	}
+	t := &transfer{id: newToken(4), from: cli.name, to: args[0], name: path.Base(args[1]), size: -1}
+	if len(args) == 3 {
+		size, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil || size < 0 {
+			s.reply(cli, fmt.Sprintf("* invalid size: %q", args[2]))
+			return
+		}
+		t.size = size
+	}
+	if t.size > s.MaxFileSize {
+		s.reply(cli, fmt.Sprintf("* %s is too large; the limit is %d bytes", t.name, s.MaxFileSize))
+		return
+	}
+	if t.to == cli.name {
+		s.reply(cli, "* cannot send a file to yourself")
+		return
+	}
+
+	s.transfers.Lock()
+	s.transfers.byID[t.id] = t
+	t.timer = time.AfterFunc(offerTimeout, func() { s.expire(t) })
+	s.transfers.Unlock()
+
+	size := "unknown size"
+	if t.size >= 0 {
+		size = strconv.FormatInt(t.size, 10) + " bytes"
+	}
+	text := fmt.Sprintf("* transfer %s: %s offers %s (%s); reply /accept %[1]s or /decline %[1]s", t.id, t.from, t.name, size)
+	if !s.deliver(t.to, text) {
+		s.forget(t)
+		s.reply(cli, fmt.Sprintf("* no such user: %q", t.to))
+		return
+	}
+	s.reply(cli, fmt.Sprintf("* transfer %s: offered %s to %s", t.id, t.name, t.to))
+}
+
+// accept handles "/accept <id>" from the receiver of an offer.
+func (s *Server) accept(cli client, args []string) {
+	t := s.offered(cli, args, "/accept")
+	if t == nil {
+		return
+	}
+	s.transfers.Lock()
+	if s.transfers.byID[t.id] != t || t.upload != "" {
+		// Expired, declined or accepted since it was found.
+		s.transfers.Unlock()
+		s.reply(cli, fmt.Sprintf("* no pending transfer %q", t.id))
+		return
+	}
+	t.upload, t.download = newToken(16), newToken(16)
+	s.transfers.byToken[t.upload] = t
+	s.transfers.byToken[t.download] = t
+	t.timer.Reset(transferTimeout)
+	s.transfers.Unlock()
+
+	s.deliver(t.from, fmt.Sprintf("* transfer %s: upload %s to %s with token %s", t.id, t.name, s.TransferAddr, t.upload))
+	s.reply(cli, fmt.Sprintf("* transfer %s: download %s from %s with token %s", t.id, t.name, s.TransferAddr, t.download))
+}
+
+// decline handles "/decline <id>" from the receiver of an offer.
+func (s *Server) decline(cli client, args []string) {
+	t := s.offered(cli, args, "/decline")
+	if t == nil {
+		return
+	}
+	s.forget(t)
+	s.deliver(t.from, fmt.Sprintf("* transfer %s: %s declined %s", t.id, t.to, t.name))
+	s.reply(cli, fmt.Sprintf("* transfer %s: declined", t.id))
+}
+
+// offered returns the not yet accepted transfer named by args, if cli is its receiver.
+func (s *Server) offered(cli client, args []string, cmd string) *transfer {
+	if len(args) != 1 {
+		s.reply(cli, "* usage: "+cmd+" <id>")
+		return nil
+	}
+	s.transfers.Lock()
+	t, ok := s.transfers.byID[args[0]]
+	pending := ok && t.to == cli.name && t.upload == ""
+	s.transfers.Unlock()
+	if !pending {
+		s.reply(cli, fmt.Sprintf("* no pending transfer %q", args[0]))
+		return nil
+	}
+	return t
+}
+
+// expire abandons a transfer that was not accepted or completed in time.
+func (s *Server) expire(t *transfer) {
+	if !s.forget(t) {
+		return
+	}
+	text := fmt.Sprintf("* transfer %s: %s expired", t.id, t.name)
+	s.deliver(t.from, text)
+	s.deliver(t.to, text)
+}
+
+// forget removes t from the index, reporting whether it was still there.
+func (s *Server) forget(t *transfer) bool {
+	s.transfers.Lock()
+	defer s.transfers.Unlock()
+	if s.transfers.byID[t.id] != t {
+		return false
+	}
+	delete(s.transfers.byID, t.id)
+	delete(s.transfers.byToken, t.upload)
+	delete(s.transfers.byToken, t.download)
+	t.timer.Stop()
+	if t.waiting != nil {
+		t.waiting.Close()
+	}
+	return true
+}
+
+// deliver sends text to the client called name, reporting whether it is connected.
+func (s *Server) deliver(name, text string) bool {
+	ok := make(chan bool, 1)
+	if !s.post(message{to: name, text: text, delivered: ok}) {
+		return false
+	}
+	select {
+	case found := <-ok:
+		return found
+	case <-s.done:
+		return false
+	}
+}
+
+// ServeTransfers accepts the connections that carry file contents on l
+// until l fails or the server is closed.
+func (s *Server) ServeTransfers(l net.Listener) error {
+	return s.serve(l, s.handleTransfer)
+}
+
+// handleTransfer reads the token that opens a transfer connection and pairs
+// it with the other side, relaying the file once both are present.
+func (s *Server) handleTransfer(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(tokenTimeout))
+	r := bufio.NewReader(conn)
+	token, err := r.ReadString('\n')
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+	token = strings.TrimSpace(token)
+
+	s.transfers.Lock()
+	t, ok := s.transfers.byToken[token]
+	if !ok {
+		s.transfers.Unlock()
+		fmt.Fprintln(conn, "unknown transfer token")
+		conn.Close()
+		return
+	}
+	uploading := token == t.upload
+	delete(s.transfers.byToken, token)
+	if t.waiting == nil {
+		t.waiting = &bufferedConn{conn, r}
+		s.transfers.Unlock()
+		return
+	}
+	peer := t.waiting
+	t.waiting = nil
+	s.transfers.Unlock()
+	s.forget(t)
+
+	src, dst := io.Reader(r), peer
+	if !uploading {
+		src, dst = peer, conn
+	}
+	s.relay(t, src, dst)
+	conn.Close()
+	peer.Close()
+}
+
+// relay copies the file from src to dst, enforcing the size limit.
+func (s *Server) relay(t *transfer, src io.Reader, dst io.Writer) {
+	limit := s.MaxFileSize
+	if t.size >= 0 {
+		limit = t.size
+	}
+	n, err := io.Copy(dst, io.LimitReader(src, limit))
+	over := false
+	if err == nil && n == limit {
+		// Only a byte past the limit shows that the file exceeds it.
+		_, probe := io.ReadFull(src, make([]byte, 1))
+		over = probe == nil
+	}
+	switch {
+	case over:
+		text := fmt.Sprintf("* transfer %s: %s aborted; it exceeds %d bytes", t.id, t.name, limit)
+		s.deliver(t.from, text)
+		s.deliver(t.to, text)
+	case err != nil:
+		text := fmt.Sprintf("* transfer %s: %s failed: %v", t.id, t.name, err)
+		s.deliver(t.from, text)
+		s.deliver(t.to, text)
+	default:
+		s.deliver(t.from, fmt.Sprintf("* transfer %s: sent %s (%d bytes) to %s", t.id, t.name, n, t.to))
+		s.deliver(t.to, fmt.Sprintf("* transfer %s: received %s (%d bytes) from %s", t.id, t.name, n, t.from))
+	}
+}
+
+// A bufferedConn reads through the buffer that consumed the connection's token.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// newToken returns n random bytes encoded as hex.
+func newToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	webhookTimeout = 10 * time.Second // How long an outgoing webhook may take to respond.
+	maxWebhookBody = 64 << 10         // Largest incoming webhook request accepted.
+)
+
+// hooker posts broadcast messages to the outgoing webhooks of their room in
+// the background, so that a slow endpoint never holds up the broadcaster.
+func (s *Server) hooker() {
+	client := &http.Client{Timeout: webhookTimeout}
+	for {
+		select {
+		case r := <-s.hooks:
+			body, err := json.Marshal(r)
+			if err != nil {
+				s.stats.webhookErrors.Add(1)
+				continue
+			}
+			for _, url := range s.Webhooks[r.Room] {
+				if err := postWebhook(client, url, body); err != nil {
+					s.stats.webhookErrors.Add(1)
+					log.Println("webhook:", err)
+				}
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// postWebhook sends one JSON-encoded record to url.
+func postWebhook(client *http.Client, url string, body []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("posting to %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// hook queues a broadcast message for the outgoing webhooks of its room.
+// Messages injected by an incoming webhook are not sent back out, so that an
+// integration wired both ways cannot loop.
+func (s *Server) hook(msg message, r Record) {
+	if msg.hooked || len(s.Webhooks[msg.room]) == 0 {
+		return
+	}
+	select {
+	case s.hooks <- r:
+	default:
+		s.stats.webhookErrors.Add(1)
+	}
+}
+
+// A webhookRequest is the body of a POST to the incoming webhook endpoint.
+type webhookRequest struct {
+	From string `json:"from"` // Name the bot speaks as.
+	Room string `json:"room"` // Defaults to the lobby.
+	Text string `json:"text"` // Each line is broadcast as a separate message.
+}
+
+// incomingWebhook broadcasts the text of a webhook request as a named bot.
+// Callers authenticate with "Authorization: Bearer <secret>".
+func (s *Server) incomingWebhook(w http.ResponseWriter, req *http.Request) {
+	if s.WebhookSecret == "" {
+		http.Error(w, "incoming webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	secret, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(s.WebhookSecret)) != 1 {
+		http.Error(w, "invalid webhook secret", http.StatusUnauthorized)
+		return
+	}
+
+	var hook webhookRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxWebhookBody)).Decode(&hook); err != nil {
+		http.Error(w, "invalid webhook request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	hook.From = strings.TrimSpace(hook.From)
+	if hook.From == "" || strings.ContainsAny(hook.From, ": \n") {
+		http.Error(w, fmt.Sprintf("invalid bot name: %q", hook.From), http.StatusBadRequest)
+		return
+	}
+	if hook.Room == "" {
+		hook.Room = lobby
+	}
+	for _, line := range strings.Split(strings.TrimRight(hook.Text, "\n"), "\n") {
+		if !s.post(message{room: hook.Room, from: hook.From, text: line, hooked: true}) {
+			http.Error(w, ErrServerClosed.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// A webhookFlag collects repeated "-webhook room=url" flags.
+type webhookFlag map[string][]string
+
+func (f webhookFlag) String() string {
+	var hooks []string
+	for _, room := range sortedKeys(f) {
+		for _, url := range f[room] {
+			hooks = append(hooks, room+"="+url)
+		}
+	}
+	return strings.Join(hooks, ",")
+}
+
+func (f webhookFlag) Set(v string) error {
+	room, url, ok := strings.Cut(v, "=")
+	if !ok || room == "" || !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("want room=http[s]://url, got %q", v)
+	}
+	f[room] = append(f[room], url)
+	return nil
+}
@@ -0,0 +1,12 @@
+module github.com/VahidBabaey/CloudComputing/lab4
+
+go 1.21.6
+
+require (
+	github.com/VahidBabaey/CloudComputing/config v0.0.0
+	github.com/VahidBabaey/CloudComputing/inventory v0.0.0
+)
+
+replace github.com/VahidBabaey/CloudComputing/inventory => ../inventory
+
+replace github.com/VahidBabaey/CloudComputing/config => ../config
@@ -1,114 +1,103 @@
 package main
 
 import (
-	"fmt"
-	"log"
-	"net/http"
-	"strconv"
-	"sync" // Imported to use sync.RWMutex for thread-safe operations.
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/VahidBabaey/CloudComputing/config"
+	"github.com/VahidBabaey/CloudComputing/inventory"
+)
+
+var (
+	addr      = flag.String("addr", "localhost:8000", "address to listen on")
+	legacy    = flag.Bool("legacy", false, "also serve the old query-string routes (/list, /price, /create, /update, /delete)")
+	dataDir   = flag.String("data", "", "directory where items are kept across restarts (in memory only if empty)")
+	auditFile = flag.String("audit", "", "file the audit log of changes is appended to (standard error if empty)")
+	apiKeys   = flag.String("api-keys", "", "API keys, as comma-separated name:role:key entries")
+	tokenKey  = flag.String("token-key", "", "HS256 key tokens are signed with")
 )
 
 func main() {
-	// Initialize db with items and a new RWMutex for thread safety.
-	db := database{
-		items: map[string]dollars{"shoes": 50, "socks": 5},
-		mutex: &sync.RWMutex{},
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], config.Options{
+		EnvPrefix: "INVENTORY",
+		Validate:  func() error { return config.CheckAddr("addr", *addr) },
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(2)
+	}
+	slog.Info("config", "config", cfg)
+	if err := run(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
-	mux := http.NewServeMux()
-	mux.HandleFunc("/list", db.list)
-	mux.HandleFunc("/price", db.price)
-	// Register new handlers for create, update, and delete operations.
-	mux.HandleFunc("/create", db.create)
-	mux.HandleFunc("/update", db.update)
-	mux.HandleFunc("/delete", db.delete)
-
-	log.Fatal(http.ListenAndServe("localhost:8000", mux))
 }
 
-type dollars float32
-
-func (d dollars) String() string { return fmt.Sprintf("$%.2f", d) } // Custom Stringer for dollars type.
+// run serves the inventory until the process is interrupted or terminated,
+// then shuts down gracefully so that deferred cleanup runs.
+func run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-// Defines a database struct with a map of items and prices, and a pointer to an RWMutex for thread safety.
-type database struct {
-	items map[string]dollars // Map to store item prices.
-	mutex *sync.RWMutex      // Mutex to synchronize access to the items map.
-}
-
-//Handler that lists all items in the database, using a read lock for thread safety.
-func (db database) list(w http.ResponseWriter, req *http.Request) {
-	db.mutex.RLock() // Lock for reading to allow concurrent reads.
-	defer db.mutex.RUnlock()
-	for item, price := range db.items {
-		fmt.Fprintf(w, "%s: %s\n", item, price)
+	// Keep items in memory unless a data directory is given.
+	var store inventory.InventoryStore = inventory.NewMemoryStore(inventory.DefaultItems...)
+	if *dataDir != "" {
+		s, err := inventory.OpenFileStore(*dataDir)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		if s.Fresh() {
+			if err := inventory.Seed(ctx, s, inventory.DefaultItems...); err != nil {
+				return err
+			}
+		}
+		store = s
 	}
-}
 
-//Handler that shows the price of a specified item, using a read lock for thread safety.
-func (db database) price(w http.ResponseWriter, req *http.Request) {
-	db.mutex.RLock() // Lock for reading to allow concurrent reads.
-	defer db.mutex.RUnlock()
-	item := req.URL.Query().Get("item")
-	if price, ok := db.items[item]; ok {
-		fmt.Fprintf(w, "%s\n", price)
-	} else {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintf(w, "no such item: %q\n", item)
-	}
-}
+	// Count requests and store failures for /metrics.
+	metrics := inventory.NewMetrics()
+	store = metrics.Instrument(store)
 
-// Handler for creating a new item in the database, using a write lock for thread safety.
-func (db database) create(w http.ResponseWriter, req *http.Request) {
-	db.mutex.Lock() // Lock for writing to prevent concurrent writes.
-	defer db.mutex.Unlock()
-	item := req.URL.Query().Get("item")
-	priceStr := req.URL.Query().Get("price")
-	price, err := strconv.ParseFloat(priceStr, 32) // Parse price as float32.
+	auth, audit, closeAudit, err := security()
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "invalid price: %q\n", priceStr)
-		return
-	}
-	if _, exists := db.items[item]; exists {
-		w.WriteHeader(http.StatusConflict)
-		fmt.Fprintf(w, "item already exists: %q\n", item)
-	} else {
-		db.items[item] = dollars(price)
-		fmt.Fprintf(w, "created %s: %s\n", item, dollars(price))
+		return err
 	}
+	defer closeAudit()
+
+	// Stream changes to the clients of /events.
+	events := inventory.NewBroker(inventory.DefaultEventBuffer)
+	go events.Run(ctx, store)
+
+	srv := &inventory.Server{Store: store, Legacy: *legacy, Auth: auth, Audit: audit, Events: events, Logger: slog.Default(), Metrics: metrics}
+	httpServer := inventory.NewHTTPServer(*addr, srv.Handler())
+	httpServer.RegisterOnShutdown(events.Close)
+	slog.Info("listening", "addr", httpServer.Addr)
+	return inventory.Serve(ctx, httpServer)
 }
 
-// Handler for updating the price of an existing item, using a write lock for thread safety.
-func (db database) update(w http.ResponseWriter, req *http.Request) {
-	db.mutex.Lock() // Lock for writing to prevent concurrent writes.
-	defer db.mutex.Unlock()
-	item := req.URL.Query().Get("item")
-	priceStr := req.URL.Query().Get("price")
-	price, err := strconv.ParseFloat(priceStr, 32) // Parse price as float32.
+// security sets up authentication from the api-keys and token-key
+// settings, and opens the audit log.
+func security() (*inventory.Authenticator, io.Writer, func() error, error) {
+	auth, err := inventory.NewAuthenticator(*apiKeys, *tokenKey)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "invalid price: %q\n", priceStr)
-		return
+		return nil, nil, nil, err
 	}
-	if _, ok := db.items[item]; !ok {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintf(w, "no such item: %q\n", item)
-	} else {
-		db.items[item] = dollars(price)
-		fmt.Fprintf(w, "updated %s: %s\n", item, dollars(price))
+	if auth == nil {
+		slog.Warn("api-keys and token-key are unset, so anyone can change the inventory")
 	}
-}
-
-// Handler for deleting an item from the database, using a write lock for thread safety.
-func (db database) delete(w http.ResponseWriter, req *http.Request) {
-	db.mutex.Lock() // Lock for writing to prevent concurrent writes.
-	defer db.mutex.Unlock()
-	item := req.URL.Query().Get("item")
-	if _, ok := db.items[item]; !ok {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintf(w, "no such item: %q\n", item)
-	} else {
-		delete(db.items, item)
-		fmt.Fprintf(w, "deleted %s\n", item)
+	if *auditFile == "" {
+		return auth, os.Stderr, func() error { return nil }, nil
+	}
+	f, err := os.OpenFile(*auditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, nil, nil, err
 	}
+	return auth, f, f.Close, nil
 }
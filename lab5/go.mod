@@ -3,6 +3,7 @@ module github.com/DavidN0809/Cloud-Computing/lab5
 go 1.21.6
 
 require (
+	github.com/VahidBabaey/CloudComputing/config v0.0.0
 	google.golang.org/grpc v1.62.0
 	google.golang.org/protobuf v1.32.0
 )
@@ -14,3 +15,5 @@ require (
 	golang.org/x/text v0.14.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20240221002015-b0ce06bbee7c // indirect
 )
+
+replace github.com/VahidBabaey/CloudComputing/config => ../config
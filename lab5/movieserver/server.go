@@ -3,18 +3,19 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 
+	"github.com/VahidBabaey/CloudComputing/config"
 	"github.com/VahidBabaey/CloudComputing/lab5/movieapi"
 	"google.golang.org/grpc"
 )
 
-const (
-	port = ":50051"
-)
+var addr = flag.String("addr", ":50051", "address to listen on")
 //Defines a server struct that embeds the unimplemented server interface. 
 // server is used to implement movieapi.MovieInfoServer
 type server struct {
@@ -62,7 +63,15 @@ func (s *server) GetMovieInfo(ctx context.Context, in *movieapi.MovieRequest) (*
 }
 
 func main() {
-	lis, err := net.Listen("tcp", port)
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], config.Options{
+		EnvPrefix: "MOVIE",
+		Validate:  func() error { return config.CheckAddr("addr", *addr) },
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("config:\n%s", cfg)
+	lis, err := net.Listen("tcp", *addr)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
@@ -1,21 +1,26 @@
-// Example use of Go mongo-driver
+//go:build ignore
+
+// Example use of Go mongo-driver; run it on its own with "go run main.go".
 package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
+	"github.com/VahidBabaey/CloudComputing/config"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-const (
-	mongodbEndpoint = "mongodb://172.17.0.3:27017" // Find this from the Mongo container
-)
+// mongoURI is the MongoDB server, set like the webserver's with -mongo or
+// MONGO_URI.
+var mongoURI = flag.String("mongo", "mongodb://localhost:27017", "MongoDB server")
 
 type Post struct {
 	ID        primitive.ObjectID `bson:"_id"`
@@ -28,15 +33,24 @@ type Post struct {
 }
 
 func main() {
+	_, err := config.Load(flag.CommandLine, os.Args[1:], config.Options{
+		EnvPrefix: "BLOG",
+		Env:       map[string]string{"mongo": "MONGO_URI"},
+		Validate:  func() error { return config.CheckURL("mongo", *mongoURI, "mongodb", "mongodb+srv") },
+	})
+	checkError(err)
+
 	// create a mongo client
 	client, err := mongo.NewClient(
-		options.Client().ApplyURI(mongodbEndpoint),
+		options.Client().ApplyURI(*mongoURI),
 	)
 	checkError(err)
 
-	// Connect to mongo
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	// Connect to mongo, giving up if it takes longer than 10 seconds
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 	err = client.Connect(ctx)
+	checkError(err)
 
 	// Disconnect
 	defer client.Disconnect(ctx)
@@ -52,6 +66,7 @@ func main() {
 		Body:      `MongoDB is a NoSQL database`,
 		CreatedAt: time.Now(),
 	})
+	checkError(err)
 	fmt.Printf("inserted id: %s\n", res.InsertedID.(primitive.ObjectID).Hex())
 
 	// filter posts tagged as mongodb
@@ -59,7 +74,7 @@ func main() {
 
 	// find one document
 	var p Post
-	if col.FindOne(ctx, filter).Decode(&p); err != nil {
+	if err := col.FindOne(ctx, filter).Decode(&p); err != nil {
 		log.Fatal(err)
 	}
 	fmt.Printf("post: %+v\n", p)
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/VahidBabaey/CloudComputing/inventory/mongostore"
+)
+
+// migrate runs "webserver migrate up|down|status": up applies the pending
+// schema migrations, down reverts the last one applied, and status lists
+// them all.
+func migrate(cmd string) error {
+	if cmd != "up" && cmd != "down" && cmd != "status" {
+		return fmt.Errorf("unknown migrate command %q; want up, down or status", cmd)
+	}
+	if *storeKind != "mongo" {
+		return fmt.Errorf("migrations are for the mongo store, not %q", *storeKind)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	s, err := openMongo(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	switch cmd {
+	case "up":
+		return up(ctx, s)
+	case "down":
+		m, err := s.MigrateDown(ctx)
+		if err != nil {
+			return err
+		}
+		slog.Info("reverted migration", "version", m.Version, "description", m.Description)
+		return nil
+	}
+	statuses, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDESCRIPTION\tAPPLIED")
+	for _, m := range statuses {
+		applied := "pending"
+		if !m.Applied.IsZero() {
+			applied = m.Applied.Format(time.RFC3339)
+		}
+		if m.Unknown {
+			applied += " (by a newer server)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Description, applied)
+	}
+	return w.Flush()
+}
+
+// up applies the pending migrations, logging each.
+func up(ctx context.Context, s *mongostore.Store) error {
+	done, err := s.MigrateUp(ctx)
+	for _, m := range done {
+		slog.Info("applied migration", "version", m.Version, "description", m.Description)
+	}
+	if err == nil && len(done) == 0 {
+		slog.Info("schema is up to date")
+	}
+	return err
+}
+
+// checkSchema fails if any migration is pending, so that a server started
+// without -auto-migrate does not serve a database it does not understand.
+func checkSchema(ctx context.Context, s *mongostore.Store) error {
+	statuses, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, m := range statuses {
+		if m.Applied.IsZero() {
+			pending = append(pending, fmt.Sprintf("%d (%s)", m.Version, m.Description))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("migrations pending: %s; run \"migrate up\" or start with -auto-migrate", strings.Join(pending, ", "))
+	}
+	return nil
+}
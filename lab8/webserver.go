@@ -2,209 +2,244 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	"net/http"
-	"strconv"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/VahidBabaey/CloudComputing/config"
+	"github.com/VahidBabaey/CloudComputing/inventory"
+	"github.com/VahidBabaey/CloudComputing/inventory/mongostore"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
-	mongodbEndpoint = "mongodb://mongodb:27017"
+	mongodbEndpoint = "mongodb://mongodb:27017/?replicaSet=rs0"
 )
 
-type Item struct {
-	ID    primitive.ObjectID `bson:"_id"`
-	Name  string             `bson:"item"`
-	Price float64            `bson:"price"`
-}
+var (
+	addr      = flag.String("addr", ":8000", "address to listen on")
+	storeKind = flag.String("store", "mongo", "where items are kept: memory, file or mongo")
+	dataDir   = flag.String("data", "data", "directory used by the file store")
+	mongoURI  = flag.String("mongo", mongodbEndpoint, "MongoDB server used by the mongo store")
+	maxPool   = flag.Uint64("mongo-max-pool", 100, "most connections to MongoDB (unlimited if 0)")
+	minPool   = flag.Uint64("mongo-min-pool", 0, "connections to MongoDB kept open while idle")
+	mongoWait = flag.Duration("mongo-wait", 2*time.Minute, "how long to keep trying to reach MongoDB at startup")
+	migrateUp = flag.Bool("auto-migrate", true, "apply pending schema migrations at startup, else refuse to start while any are pending (mongo store)")
+	timeout   = flag.Duration("timeout", 10*time.Second, "time each request, except streams, has to use the store (unlimited if 0)")
+	cacheSize = flag.Int("cache-size", 1000, "most items kept in memory for reads (no cache if 0)")
+	cacheTTL  = flag.Duration("cache-ttl", 30*time.Second, "how long items are kept in memory for reads")
+	follow    = flag.Bool("cache-follow", false, "drop cached items as other servers sharing the store change them (needs a replica set for the mongo store)")
+	legacy    = flag.Bool("legacy", false, "also serve the old query-string routes (/list, /price, /create, /update, /delete)")
+	auditFile = flag.String("audit", "", "file the audit log of changes is appended to (standard error if empty)")
+	apiKeys   = flag.String("api-keys", "", "API keys, as comma-separated name:role:key entries")
+	tokenKey  = flag.String("token-key", "", "HS256 key tokens are signed with")
+)
 
 func main() {
-	// Create a MongoDB client
-	client, err := mongo.NewClient(options.Client().ApplyURI(mongodbEndpoint))
-	checkError(err)
-
-	// Connect to MongoDB
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	err = client.Connect(ctx)
-	checkError(err)
-
-	// Disconnect after the main function completes
-	defer client.Disconnect(ctx)
-
-	// Call seedData to seed initial data into MongoDB
-	seedData(client)
-
-	// Create a new ServeMux and database instance
-	mux := http.NewServeMux()
-	db := &database{client: client}
-
-	// Register handlers for different routes
-	mux.HandleFunc("/list", db.list)
-	mux.HandleFunc("/price", db.price)
-	mux.HandleFunc("/create", db.create)
-	mux.HandleFunc("/update", db.update)
-	mux.HandleFunc("/delete", db.delete)
-
-	// Start the server
-	log.Fatal(http.ListenAndServe(":8000", mux))
-}
-
-// database is a struct that holds a reference to the MongoDB client
-type database struct {
-	client *mongo.Client
-}
-
-func seedData(client *mongo.Client) {
-    collection := client.Database("myDB").Collection("inventory")
-
-    // Define initial data
-    initialData := []interface{}{
-        Item{Name: "shoes", Price: 50},
-        Item{Name: "socks", Price: 5},
-    }
-
-    // Insert each item in the database if it doesn't exist
-    for _, data := range initialData {
-        filter := bson.M{"item": data.(Item).Name}
-        update := bson.M{
-            "$setOnInsert": bson.M{
-                "_id":   primitive.NewObjectID(),
-                "item":  data.(Item).Name,
-                "price": data.(Item).Price,
-            },
-        }
-        opts := options.FindOneAndUpdate().SetUpsert(true)
-
-        var result Item
-        err := collection.FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&result)
-        if err != nil {
-            if err == mongo.ErrNoDocuments {
-                log.Printf("Inserted initial data: %v", data)
-            } else {
-                log.Printf("Failed to insert initial data: %v", err)
-            }
-        } else {
-            log.Printf("Initial data already exists: %v", data)
-        }
-    }
-}
-
-// list handles the "/list" route and lists all items in the inventory
-func (db *database) list(w http.ResponseWriter, req *http.Request) {
-	collection := db.client.Database("myDB").Collection("inventory")
-	cursor, err := collection.Find(context.TODO(), bson.M{})
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], config.Options{
+		EnvPrefix: "INVENTORY",
+		Env:       map[string]string{"mongo": "MONGO_URI"},
+		Validate:  validate,
+	})
 	if err != nil {
-		http.Error(w, "Failed to list items", http.StatusInternalServerError)
+		slog.Error(err.Error())
+		os.Exit(2)
+	}
+	if flag.Arg(0) == "healthcheck" {
+		// For the container's health check, which has no curl to use.
+		if err := inventory.CheckHealth(*addr); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
 		return
 	}
-	defer cursor.Close(context.Background())
-
-	for cursor.Next(context.Background()) {
-		var item Item
-		if err := cursor.Decode(&item); err != nil {
-			http.Error(w, "Failed to decode item", http.StatusInternalServerError)
-			return
+	if flag.Arg(0) == "migrate" {
+		if err := migrate(flag.Arg(1)); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
 		}
-		fmt.Fprintf(w, "%s: $%.2f\n", item.Name, item.Price)
+		return
+	}
+	slog.Info("config", "config", cfg)
+	if err := run(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
 }
 
-// price handles the "/price" route and retrieves the price of a specific item
-func (db *database) price(w http.ResponseWriter, req *http.Request) {
-	itemQuery := req.URL.Query().Get("item")
-	collection := db.client.Database("myDB").Collection("inventory")
-	filter := bson.M{"item": itemQuery}
-
-	var result Item
-	err := collection.FindOne(context.TODO(), filter).Decode(&result)
-	if err != nil {
-		http.Error(w, "Item not found", http.StatusNotFound)
-		return
+// validate checks the settings that could otherwise fail long after
+// startup.
+func validate() error {
+	errs := []error{config.CheckAddr("addr", *addr), config.CheckURL("mongo", *mongoURI, "mongodb", "mongodb+srv")}
+	if *cacheSize < 0 {
+		errs = append(errs, fmt.Errorf("cache-size %d is negative", *cacheSize))
 	}
-
-	fmt.Fprintf(w, "%s: $%.2f\n", result.Name, result.Price)
+	if *cacheSize > 0 && *cacheTTL <= 0 {
+		errs = append(errs, fmt.Errorf("cache-ttl %v is not positive", *cacheTTL))
+	}
+	if *maxPool != 0 && *minPool > *maxPool {
+		errs = append(errs, fmt.Errorf("mongo-min-pool %d is more than mongo-max-pool %d", *minPool, *maxPool))
+	}
+	return errors.Join(errs...)
 }
 
-// create handles the "/create" route and creates a new item in the inventory
-func (db *database) create(w http.ResponseWriter, req *http.Request) {
-	item := req.URL.Query().Get("item")
-	priceStr := req.URL.Query().Get("price")
-	price, err := strconv.ParseFloat(priceStr, 64)
+// run serves the inventory until the process is interrupted or terminated,
+// then shuts down gracefully, draining requests in flight before the store,
+// and with it the MongoDB client, is closed.
+func run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, closeStore, err := openStore(ctx, *storeKind)
 	if err != nil {
-		http.Error(w, "Invalid price format", http.StatusBadRequest)
-		return
+		return err
 	}
-
-	collection := db.client.Database("myDB").Collection("inventory")
-	newItem := Item{
-		ID:    primitive.NewObjectID(),
-		Name:  item,
-		Price: price,
+	defer closeStore()
+
+	// Bring MongoDB up to date, which seeds it, or check that it is, or
+	// create the initial items in other stores if they are missing
+	if m, ok := store.(*mongostore.Store); ok {
+		if *migrateUp {
+			err = up(ctx, m)
+		} else {
+			err = checkSchema(ctx, m)
+		}
+	} else {
+		seedCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err = inventory.Seed(seedCtx, store, inventory.DefaultItems...)
+		cancel()
 	}
-	_, err = collection.InsertOne(context.TODO(), newItem)
 	if err != nil {
-		http.Error(w, "Failed to create item", http.StatusInternalServerError)
-		return
+		return err
 	}
 
-	fmt.Fprintf(w, "Created %s: $%.2f\n", item, price)
-}
+	// Count requests and store failures for /metrics
+	metrics := inventory.NewMetrics()
+	store = metrics.Instrument(store)
 
-// update handles the "/update" route and updates the price of an existing item
-func (db *database) update(w http.ResponseWriter, req *http.Request) {
-	item := req.URL.Query().Get("item")
-	priceStr := req.URL.Query().Get("price")
-	price, err := strconv.ParseFloat(priceStr, 64)
-	if err != nil {
-		http.Error(w, "Invalid price format", http.StatusBadRequest)
-		return
+	// Keep items read in memory, above all for /price
+	if *cacheSize > 0 {
+		cache := inventory.NewCache(store, *cacheSize, *cacheTTL, metrics)
+		if *follow {
+			go followStore(ctx, cache)
+		}
+		store = cache
 	}
 
-	collection := db.client.Database("myDB").Collection("inventory")
-	filter := bson.M{"item": item}
-	update := bson.M{"$set": bson.M{"price": price}}
-
-	result, err := collection.UpdateOne(context.TODO(), filter, update)
+	auth, audit, closeAudit, err := security()
 	if err != nil {
-		http.Error(w, "Failed to update item", http.StatusInternalServerError)
-		return
-	}
-	if result.ModifiedCount == 0 {
-		http.Error(w, "Item not found", http.StatusNotFound)
-		return
+		return err
 	}
+	defer closeAudit()
 
-	fmt.Fprintf(w, "Updated %s: $%.2f\n", item, price)
-}
-
-// delete handles the "/delete" route and deletes an item from the inventory
-func (db *database) delete(w http.ResponseWriter, req *http.Request) {
-	item := req.URL.Query().Get("item")
+	// Stream changes to the clients of /events
+	events := inventory.NewBroker(inventory.DefaultEventBuffer)
+	go events.Run(ctx, store)
 
-	collection := db.client.Database("myDB").Collection("inventory")
-	filter := bson.M{"item": item}
+	// Start the server
+	srv := &inventory.Server{Store: store, Legacy: *legacy, Auth: auth, Audit: audit, Events: events, Logger: slog.Default(), Metrics: metrics, RequestTimeout: *timeout}
+	httpServer := inventory.NewHTTPServer(*addr, srv.Handler())
+	httpServer.RegisterOnShutdown(events.Close)
+	slog.Info("listening", "addr", httpServer.Addr, "store", *storeKind)
+	return inventory.Serve(ctx, httpServer)
+}
 
-	result, err := collection.DeleteOne(context.TODO(), filter)
-	if err != nil {
-		http.Error(w, "Failed to delete item", http.StatusInternalServerError)
-		return
+// openStore opens the store named by the -store flag, returning it with a
+// function that closes it.
+func openStore(ctx context.Context, kind string) (inventory.InventoryStore, func() error, error) {
+	switch kind {
+	case "memory":
+		return inventory.NewMemoryStore(), func() error { return nil }, nil
+	case "file":
+		s, err := inventory.OpenFileStore(*dataDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Close, nil
+	case "mongo":
+		s, err := openMongo(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown store %q; want memory, file or mongo", kind)
 	}
-	if result.DeletedCount == 0 {
-		http.Error(w, "Item not found", http.StatusNotFound)
-		return
+}
+
+// openMongo connects to MongoDB, which may still be starting, trying again
+// with exponential backoff for up to -mongo-wait.
+func openMongo(ctx context.Context) (*mongostore.Store, error) {
+	opts := options.Client().SetMaxPoolSize(*maxPool).SetMinPoolSize(*minPool)
+	ctx, cancel := context.WithTimeout(ctx, *mongoWait)
+	defer cancel()
+	delay := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancelAttempt := context.WithTimeout(ctx, 10*time.Second)
+		s, err := mongostore.Open(attemptCtx, *mongoURI, opts)
+		cancelAttempt()
+		if err == nil {
+			return s, nil
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("MongoDB unreachable after %d attempts: %w", attempt, err)
+		}
+		slog.Warn("connecting to MongoDB", "attempt", attempt, "error", err.Error(), "retry_in", delay.String())
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("MongoDB unreachable after %d attempts: %w", attempt, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, 15*time.Second)
 	}
+}
 
-	fmt.Fprintf(w, "Deleted %s\n", item)
+// followStore drops cached items as the store changes until ctx is done,
+// watching the store again, with exponential backoff, whenever that fails.
+func followStore(ctx context.Context, cache *inventory.Cache) {
+	delay := 500 * time.Millisecond
+	for {
+		start := time.Now()
+		err := cache.Follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > time.Minute {
+			delay = 500 * time.Millisecond
+		}
+		slog.Warn("watching the store for the cache", "error", err, "retry_in", delay.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, 15*time.Second)
+	}
 }
 
-func checkError(err error) {
+// security sets up authentication from the api-keys and token-key
+// settings, and opens the audit log.
+func security() (*inventory.Authenticator, io.Writer, func() error, error) {
+	auth, err := inventory.NewAuthenticator(*apiKeys, *tokenKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if auth == nil {
+		slog.Warn("api-keys and token-key are unset, so anyone can change the inventory")
+	}
+	if *auditFile == "" {
+		return auth, os.Stderr, func() error { return nil }, nil
+	}
+	f, err := os.OpenFile(*auditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, nil, err
 	}
+	return auth, f, f.Close, nil
 }